@@ -37,46 +37,92 @@ import (
 type aesCryptor struct {
 	rawkey []byte
 	key    []byte
+	id     KeyID
 	block  cipher.Block
+	aead   cipher.AEAD
 }
 
 // The newAESCryptor return a pointer to the AESCryptor struct,
 // error is returned if any error happened to the aes.NewCipher
 func newAESCryptor(secret []byte) (result *aesCryptor, err error) {
+	data := sha256.Sum256(secret)
+	return newAESCryptorWithKey(secret, data[0:])
+}
+
+// The newAESCryptorWithKey return a pointer to the AESCryptor struct with the 32 bytes key
+// which is already derived from the secret
+func newAESCryptorWithKey(secret, key []byte) (result *aesCryptor, err error) {
 	result = new(aesCryptor)
 	result.rawkey = secret
-	data := sha256.Sum256(secret)
-	result.key = data[0:]
+	result.key = key
+	result.id = NewKeyID(result.key)
 
 	result.block, err = aes.NewCipher(result.key)
 	if err != nil {
 		return nil, err
 	}
+
+	result.aead, err = cipher.NewGCM(result.block)
+	if err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
-// The encrypt function encrypt the data with the cipher value that is calculated
-// when the AESCryptor is initialized
-func (ac *aesCryptor) encrypt(data []byte) ([]byte, error) {
-	output := make([]byte, aes.BlockSize+len(data))
-	iv := output[:aes.BlockSize]
-	encrypted := output[aes.BlockSize:]
+// The Suite function returns SuiteAESGCM
+func (ac *aesCryptor) Suite() Suite {
+	return SuiteAESGCM
+}
+
+// The KeyID function returns the identifier of the key
+func (ac *aesCryptor) KeyID() KeyID {
+	return ac.id
+}
+
+// The Encrypt function encrypt the data with AES-GCM using the cipher value that is
+// calculated when the AESCryptor is initialized, the output is nonce || ciphertext || tag.
+// The additionalData is authenticated but not encrypted, and must be given again to decrypt
+func (ac *aesCryptor) Encrypt(data, additionalData []byte) ([]byte, error) {
+	nonceSize := ac.aead.NonceSize()
 
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	output := make([]byte, nonceSize, nonceSize+len(data)+ac.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, output); err != nil {
 		return nil, err
 	}
 
-	stream := cipher.NewCFBEncrypter(ac.block, iv)
-
-	stream.XORKeyStream(encrypted, data)
-	return output, nil
+	return ac.aead.Seal(output, output, data, additionalData), nil
 }
 
-// The decrypt function decrypt the data with the cipher value that is calculated
-// when the AESCryptor is initialized. Be cautious that the decrypt directly update
+// The Decrypt function decrypt the data with the cipher value that is calculated
+// when the AESCryptor is initialized, ErrTampered is returned if the data or the additionalData
+// fails the authentication. Be cautious that the decrypt directly update
 // the decrypted value in the data field, thus make sure the data field is modifiable,
 // otherwise copy the original encrypted content to a new []byte before calling this function
-func (ac *aesCryptor) decrypt(data []byte) ([]byte, error) {
+func (ac *aesCryptor) Decrypt(data, additionalData []byte) ([]byte, error) {
+	nonceSize := ac.aead.NonceSize()
+	if len(data) < nonceSize+ac.aead.Overhead() {
+		return []byte(""), errors.New("cipherText too short")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	dec, err := ac.aead.Open(ciphertext[:0], nonce, ciphertext, additionalData)
+	if err != nil {
+		return []byte(""), ErrTampered
+	}
+	return dec, nil
+}
+
+// The Close function zeroes the secret and the key kept by the cryptor, the cryptor must not be used
+// afterwards. The expanded key inside the cipher block cannot be zeroed, it is released with the cryptor
+func (ac *aesCryptor) Close() error {
+	zero(ac.rawkey)
+	zero(ac.key)
+	return nil
+}
+
+// The decryptCFB function decrypt the values written in the legacy AES-CFB format,
+// which carry no authentication tag
+func (ac *aesCryptor) decryptCFB(data []byte) ([]byte, error) {
 	if len(data) < aes.BlockSize {
 		return []byte(""), errors.New("cipherText too short")
 	}
@@ -31,12 +31,12 @@ func TestEnc(t *testing.T) {
 			t.Errorf("newAESCryptor with key '%v' return err: %s", iter.secret, err)
 			continue
 		}
-		enc, err := ac.encrypt(iter.content)
+		enc, err := ac.Encrypt(iter.content, nil)
 		if err != nil {
 			t.Errorf("Unable to encrypt '%v' with key '%v': %v", iter.content, iter.secret, err)
 			continue
 		}
-		dec, err := ac.decrypt(enc)
+		dec, err := ac.Decrypt(enc, nil)
 		if err != nil {
 			t.Errorf("Unable to decrypt '%v' with key '%v': %v", enc, iter.secret, err)
 			continue
@@ -79,11 +79,11 @@ func BenchmarkReuseCryptor(b *testing.B) {
 
 	for n := 0; n < b.N; n++ {
 
-		enc, err := ac.encrypt(content)
+		enc, err := ac.Encrypt(content, nil)
 		if err != nil {
 			b.Errorf("Unable to encrypt '%v' with key '%v': %v", content, secret, err)
 		}
-		dec, err := ac.decrypt(enc)
+		dec, err := ac.Decrypt(enc, nil)
 		if err != nil {
 			b.Errorf("Unable to decrypt '%v' with key '%v': %v", enc, secret, err)
 		}
@@ -103,11 +103,11 @@ func BenchmarkNewCryptor(b *testing.B) {
 			b.Errorf("newAESCryptor with key '%v' return err: %s", secret, err)
 		}
 
-		enc, err := ac.encrypt(content)
+		enc, err := ac.Encrypt(content, nil)
 		if err != nil {
 			b.Errorf("Unable to encrypt '%v' with key '%v': %v", content, secret, err)
 		}
-		dec, err := ac.decrypt(enc)
+		dec, err := ac.Decrypt(enc, nil)
 		if err != nil {
 			b.Errorf("Unable to decrypt '%v' with key '%v': %v", enc, secret, err)
 		}
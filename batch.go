@@ -0,0 +1,61 @@
+package boltsec
+
+import (
+	"sort"
+)
+
+// The SaveMany function stores the records of the map into the bucket in one transaction, the keys of the
+// map are the keys of the records, see Save. Either all the records are stored or none of them, and the
+// cost of the commit of the transaction is paid once for all of them.
+func (dbm *DBManager) SaveMany(bucket string, records map[string]interface{}) error {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	//the records are stored in the order of the keys, which is the fastest for bolt
+	sort.Strings(keys)
+
+	save := func(tx *Tx) error {
+		for _, key := range keys {
+			if err := tx.Put(bucket, key, records[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return dbm.Update(save)
+}
+
+// Batch runs the function in a read-write transaction which is shared with the functions given to Batch
+// by other goroutines at the same time, so that their writes are committed together, see bolt.DB.Batch.
+// It is not related to the batch mode of SetBatchMode. The function can be called more than once if a
+// function of the same transaction fails, thus it must be idempotent, and its changes are only committed
+// when Batch returns.
+//
+// Batch only improves the throughput of the concurrent writers, a single writer should use Update or
+// SaveMany instead, as each Batch waits for the other writers up to bolt.DB.MaxBatchDelay.
+func (dbm *DBManager) Batch(fn func(tx *Tx) error) error {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return err
+	}
+	defer dbm.closeDB()
+
+	batch := func(tx *boltsecTx) error {
+		return fn(&Tx{dbm: dbm, tx: tx})
+	}
+
+	return dbm.db.batch(batch)
+}
+
+// The SaveBatch function stores the record the same as Save, in a transaction shared with the other
+// goroutines which call SaveBatch or Batch at the same time, see Batch.
+func (dbm *DBManager) SaveBatch(bucket, key string, data interface{}) error {
+	save := func(tx *Tx) error {
+		return tx.Put(bucket, key, data)
+	}
+
+	return dbm.Batch(save)
+}
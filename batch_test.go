@@ -0,0 +1,147 @@
+package boltsec
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDBMSaveMany(t *testing.T) {
+	var err error
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"article"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	records := make(map[string]interface{})
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("ID-%04d", i)
+		records[id] = Article{ID: id, Title: "input with more than 16 characters"}
+	}
+	if err = dbm.SaveMany("article", records); err != nil {
+		t.Fatalf("SaveMany return err: %s", err)
+	}
+
+	list, err := NewStore[Article](dbm, "article").List("ID-")
+	if err != nil || len(list) != len(records) {
+		t.Fatalf("List return %d records, err: %v", len(list), err)
+	}
+	for _, a := range list {
+		if records[a.ID] != a {
+			t.Errorf("List return %+v, expect: %+v", a, records[a.ID])
+		}
+	}
+
+	//none of the records are stored if one of them fails
+	failed := map[string]interface{}{"ID-0100": Article{ID: "ID-0100"}, "ID-0101": nil}
+	if err = dbm.SaveMany("article", failed); err == nil {
+		t.Errorf("SaveMany with nil data return no err")
+	}
+	if _, err = dbm.Get("article", "ID-0100"); err != ErrNotFound {
+		t.Errorf("Get record of failed SaveMany return err: %v, expect: %v", err, ErrNotFound)
+	}
+}
+
+func TestDBMSaveBatch(t *testing.T) {
+	for _, batchMode := range []bool{false, true} {
+		dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", batchMode, []string{"article"}, WithIntegrity())
+		if err != nil {
+			t.Fatalf("NewDBManager return err: %s", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				if err := dbm.SaveBatch("article", id, Article{ID: id, Title: "input with more than 16 characters"}); err != nil {
+					t.Errorf("SaveBatch %s return err: %s", id, err)
+				}
+			}(fmt.Sprintf("ID-%04d", i))
+		}
+		wg.Wait()
+
+		if keys, err := dbm.GetKeyList("article", "ID-"); err != nil || len(keys) != 50 {
+			t.Errorf("GetKeyList after SaveBatch return %d keys, err: %v", len(keys), err)
+		}
+		if res, err := dbm.Get("article", "ID-0042"); err != nil || !bytes.Contains(res, []byte("ID-0042")) {
+			t.Errorf("Get after SaveBatch return %s, err: %v", res, err)
+		}
+		if report, err := dbm.Verify(); err != nil || len(report.Issues) != 0 {
+			t.Errorf("Verify after SaveBatch return %+v, err: %v", report, err)
+		}
+		dbm.Close()
+	}
+}
+
+// The benchmarks of the writes compare the Save of each record in its own transaction with the bulk and
+// the concurrent write paths
+func BenchmarkDBMSave(b *testing.B) {
+	dbm, err := NewDBManager("test.dat", b.TempDir(), "secret", true, []string{"article"})
+	if err != nil {
+		b.Fatalf("NewDBManager return err: %s", err)
+	}
+	defer dbm.Close()
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err = dbm.Save("article", fmt.Sprintf("ID-%08d", n), data); err != nil {
+			b.Fatalf("Save return err: %s", err)
+		}
+	}
+}
+
+func BenchmarkDBMSaveMany(b *testing.B) {
+	dbm, err := NewDBManager("test.dat", b.TempDir(), "secret", true, []string{"article"})
+	if err != nil {
+		b.Fatalf("NewDBManager return err: %s", err)
+	}
+	defer dbm.Close()
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	b.ResetTimer()
+	for n := 0; n < b.N; n += 100 {
+		records := make(map[string]interface{})
+		for i := n; i < n+100 && i < b.N; i++ {
+			records[fmt.Sprintf("ID-%08d", i)] = data
+		}
+		if err = dbm.SaveMany("article", records); err != nil {
+			b.Fatalf("SaveMany return err: %s", err)
+		}
+	}
+}
+
+func BenchmarkDBMSaveParallel(b *testing.B) {
+	benchmarkParallel(b, (*DBManager).Save)
+}
+
+func BenchmarkDBMSaveBatchParallel(b *testing.B) {
+	benchmarkParallel(b, (*DBManager).SaveBatch)
+}
+
+// The benchmarkParallel function runs the save function from many goroutines
+func benchmarkParallel(b *testing.B, save func(dbm *DBManager, bucket, key string, data interface{}) error) {
+	dbm, err := NewDBManager("test.dat", b.TempDir(), "secret", true, []string{"article"})
+	if err != nil {
+		b.Fatalf("NewDBManager return err: %s", err)
+	}
+	defer dbm.Close()
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	var id int64
+	//the batches of bolt are only filled by many writers, the commit waits for them up to MaxBatchDelay
+	b.SetParallelism(256)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := save(dbm, "article", fmt.Sprintf("ID-%08d", atomic.AddInt64(&id, 1)), data); err != nil {
+				b.Errorf("save return err: %s", err)
+				return
+			}
+		}
+	})
+}
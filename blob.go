@@ -0,0 +1,551 @@
+package boltsec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"io"
+)
+
+// The size of the chunks of the blobs if BlobChunkSize is not greater than 0
+const defaultBlobChunkSize = 64 * 1024
+
+// BlobChunkSize is the size of the chunks of the blobs stored by PutReader, each chunk is encrypted and
+// authenticated on its own, thus a blob is never held in the memory as a whole
+var BlobChunkSize = defaultBlobChunkSize
+
+// The number of chunks written in one transaction by PutReader
+const blobBatchChunks = 64
+
+const (
+	blobIDSize     = 16
+	blobHeaderSize = blobIDSize + 4 + 8
+	// the size of the trailer of the binding of the chunks, see blobBinding
+	blobTrailerSize = blobIDSize + 8 + 1
+)
+
+// The prefix of the sub-buckets of the metadata bucket which keep the chunks of a PutReader replacing a
+// record, followed by the blob id
+const blobStagingPrefix = "blob/"
+
+// The key of the header of a blob in its sub-bucket, the keys of the chunks are the blob id and the
+// index of the chunk, see chunkKey
+var blobHeaderKey = []byte("header")
+
+// The blobHeader struct is stored in the sub-bucket of a blob, encrypted if the secret is set. The id
+// is a random identifier of each PutReader, the chunks of other puts cannot be mixed into the blob
+type blobHeader struct {
+	id        []byte
+	chunkSize int64
+	length    int64
+}
+
+// PutReader stores the data read from the reader until io.EOF as a blob of the key, the existing record or
+// blob of the key is replaced. The data is split into chunks of BlobChunkSize, each chunk is encrypted if
+// the secret is set and authenticated with the bucket, the key, its index and whether it is the last chunk,
+// so that the chunks cannot be reordered, truncated or mixed with the chunks of another blob.
+//
+// The blob is kept in a sub-bucket of the key, thus it is not returned by GetByPrefix, GetKeyList and
+// GetOne, read it with GetReader or GetWriter. The chunks are written in several transactions, the old
+// blob or record is replaced only when all of them are written, and the chunks are deleted if the
+// PutReader fails. The chunks are neither compressed nor padded. A Rekey waits until the PutReader is done,
+// as all the chunks of a blob are encrypted with the same keys.
+func (dbm *DBManager) PutReader(bucket, key string, r io.Reader) (n int64, err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	if key == "" {
+		return 0, ErrKeyInvalid
+	}
+	if isReserved(bucket) {
+		return 0, ErrBucketReserved
+	}
+
+	//the keys must not be replaced by a Rekey or Lock while the chunks are written in several transactions
+	dbm.rekeyMu.RLock()
+	defer dbm.rekeyMu.RUnlock()
+
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return
+	}
+
+	chunkSize := BlobChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultBlobChunkSize
+	}
+
+	k := dbm.storageKey(keys, bucket, []byte(key))
+	header := blobHeader{id: make([]byte, blobIDSize), chunkSize: int64(chunkSize)}
+	if _, err = io.ReadFull(rand.Reader, header.id); err != nil {
+		return
+	}
+
+	buf := make([]byte, chunkSize)
+	var index uint64
+	//the chunks are staged in the metadata bucket if a record of the key is replaced, as the record and
+	//the sub-bucket of the blob cannot have the same key, and the record is kept until the blob is complete
+	var staged bool
+	staging := []byte(blobStagingPrefix + string(header.id))
+	for last := false; !last; {
+		write := func(tx *boltsecTx) error {
+			bkt := tx.Bucket([]byte(bucket))
+			if bkt == nil {
+				return ErrBucketNotFound
+			}
+
+			if index == 0 {
+				staged = bkt.Get(k) != nil && bkt.Bucket(k) == nil
+			}
+
+			var sub *bolt.Bucket
+			var err error
+			if staged {
+				sub, err = tx.Bucket([]byte(metaBucket)).CreateBucketIfNotExists(staging)
+			} else {
+				sub, err = bkt.CreateBucketIfNotExists(k)
+			}
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < blobBatchChunks && !last; i++ {
+				size, err := io.ReadFull(r, buf)
+				switch err {
+				case nil:
+				case io.EOF, io.ErrUnexpectedEOF:
+					//the last chunk can be empty if the data ends at the end of a chunk
+					last = true
+				default:
+					return err
+				}
+
+				chunk, err := sealChunk(keys, bucket, k, header.id, index, last, buf[:size])
+				if err != nil {
+					return fmt.Errorf("%w: %s", ErrEncrypt, err)
+				}
+				if err = sub.Put(chunkKey(header.id, index), chunk); err != nil {
+					return err
+				}
+
+				n += int64(size)
+				index++
+			}
+
+			if !last {
+				return nil
+			}
+
+			//the blob is complete, replace the record or the old blob
+			if staged {
+				if sub, err = dbm.unstageBlob(tx, keys, bkt, bucket, k, staging); err != nil {
+					return err
+				}
+			}
+
+			var old []byte
+			if v := sub.Get(blobHeaderKey); v != nil {
+				//the chunks of an old header which cannot be opened are left, they are never read
+				if h, err := openBlobHeader(keys, bucket, k, append([]byte(nil), v...)); err == nil {
+					old = h.id
+				}
+			}
+
+			header.length = n
+			enc, err := header.seal(keys, bucket, k)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrEncrypt, err)
+			}
+			if err = sub.Put(blobHeaderKey, enc); err != nil {
+				return err
+			}
+
+			//only the chunks of the old blob are deleted, the chunks of a PutReader still running are kept
+			if old != nil {
+				return deleteChunks(sub, old)
+			}
+			return nil
+		}
+
+		if err = dbm.db.update(write); err != nil {
+			if index > 0 {
+				dbm.discardBlob(bucket, k, header.id, staged, staging)
+			}
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// The unstageBlob function deletes the record of the key and moves the chunks staged by PutReader into the
+// sub-bucket of the blob, which is returned
+func (dbm *DBManager) unstageBlob(tx *boltsecTx, keys *keyring, bkt *bolt.Bucket, bucket string, k, staging []byte) (*bolt.Bucket, error) {
+	if bkt.Get(k) != nil && bkt.Bucket(k) == nil {
+		if err := dbm.deleteRecord(tx, keys, bkt, bucket, k); err != nil {
+			return nil, err
+		}
+	}
+
+	sub, err := bkt.CreateBucketIfNotExists(k)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := tx.Bucket([]byte(metaBucket))
+	err = meta.Bucket(staging).ForEach(func(ck, v []byte) error {
+		return sub.Put(append([]byte(nil), ck...), append([]byte(nil), v...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, meta.DeleteBucket(staging)
+}
+
+// The discardBlob function deletes the chunks written by a PutReader which failed, the errors are ignored
+// as the chunks without the header are never read
+func (dbm *DBManager) discardBlob(bucket string, k, id []byte, staged bool, staging []byte) {
+	discard := func(tx *boltsecTx) error {
+		if staged {
+			return tx.Bucket([]byte(metaBucket)).DeleteBucket(staging)
+		}
+
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil || bkt.Bucket(k) == nil {
+			return nil
+		}
+		return deleteChunks(bkt.Bucket(k), id)
+	}
+
+	if err := dbm.db.update(discard); err != nil && Debug {
+		Logger.Printf("PutReader discard the chunks of bucket %s blob %s return %s", bucket, k, err)
+	}
+}
+
+// The deleteChunks function deletes the chunks of the blob id from the sub-bucket of the blob
+func deleteChunks(sub *bolt.Bucket, id []byte) error {
+	//the keys are collected first as the cursor must not be used after the bucket is changed
+	chunks := make([][]byte, 0)
+	cursor := sub.Cursor()
+	for ck, _ := cursor.Seek(id); ck != nil && bytes.HasPrefix(ck, id); ck, _ = cursor.Next() {
+		chunks = append(chunks, append([]byte(nil), ck...))
+	}
+
+	for _, ck := range chunks {
+		if err := sub.Delete(ck); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetReader returns the reader of the blob of the key stored by PutReader, which decrypts and verifies the
+// chunks on demand, each in its own transaction, and can seek to any offset of the blob. ErrBlobChanged is
+// returned by the reader if the blob is replaced or deleted after the GetReader.
+func (dbm *DBManager) GetReader(bucket, key string) (io.ReadSeeker, error) {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return nil, err
+	}
+	defer dbm.closeDB()
+
+	if key == "" {
+		return nil, ErrKeyInvalid
+	}
+	if isReserved(bucket) {
+		return nil, ErrBucketReserved
+	}
+
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &blobReader{dbm: dbm, bucket: bucket, k: dbm.storageKey(keys, bucket, []byte(key)), index: -1}
+	load := func(tx *boltsecTx) error {
+		sub, err := blobBucket(tx, bucket, r.k)
+		if err != nil {
+			return err
+		}
+
+		v := sub.Get(blobHeaderKey)
+		if v == nil {
+			return ErrBlobNotFound
+		}
+		r.header, err = openBlobHeader(keys, bucket, r.k, v)
+		return err
+	}
+
+	if err = dbm.db.view(load); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetWriter writes the blob of the key stored by PutReader to the writer, and returns the number of bytes
+// written. The blob is verified chunk by chunk, thus an error can be returned after a part of it is written.
+func (dbm *DBManager) GetWriter(bucket, key string, w io.Writer) (int64, error) {
+	r, err := dbm.GetReader(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, r)
+}
+
+// The blobReader struct is the io.ReadSeeker returned by GetReader, it keeps the current chunk only
+type blobReader struct {
+	dbm    *DBManager
+	bucket string
+	k      []byte
+	header blobHeader
+	offset int64
+	// index is the index of the chunk, -1 if no chunk is loaded
+	index int64
+	chunk []byte
+}
+
+// The Read function reads the blob from the offset
+func (r *blobReader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.header.length {
+		return 0, io.EOF
+	}
+
+	index := r.offset / r.header.chunkSize
+	if index != r.index {
+		if err = r.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, r.chunk[r.offset-index*r.header.chunkSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// The Seek function sets the offset for the next Read, the same as io.Seeker
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.header.length
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// The load function reads and decrypts the chunk of the index
+func (r *blobReader) load(index int64) (err error) {
+	if err = r.dbm.openDB(); err != nil {
+		return
+	}
+	defer r.dbm.closeDB()
+
+	keys, err := r.dbm.keysFor(r.bucket)
+	if err != nil {
+		return
+	}
+
+	last := index == r.header.length/r.header.chunkSize
+	size := r.header.chunkSize
+	if last {
+		size = r.header.length % r.header.chunkSize
+	}
+
+	load := func(tx *boltsecTx) error {
+		sub, err := blobBucket(tx, r.bucket, r.k)
+		if err != nil {
+			return ErrBlobChanged
+		}
+
+		v := sub.Get(chunkKey(r.header.id, uint64(index)))
+		if v == nil {
+			return ErrBlobChanged
+		}
+
+		chunk, err := openChunk(keys, r.bucket, r.k, r.header.id, uint64(index), last, v)
+		if err != nil {
+			return err
+		}
+		if int64(len(chunk)) != size {
+			return decryptError(r.bucket, r.k, ErrTampered)
+		}
+
+		r.index, r.chunk = index, chunk
+		return nil
+	}
+
+	return r.dbm.db.view(load)
+}
+
+// The blobBucket function returns the sub-bucket of the blob
+func blobBucket(tx *boltsecTx, bucket string, k []byte) (*bolt.Bucket, error) {
+	bkt := tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil, ErrBucketNotFound
+	}
+
+	sub := bkt.Bucket(k)
+	if sub == nil {
+		return nil, ErrBlobNotFound
+	}
+	return sub, nil
+}
+
+// The chunkKey function returns the key of the chunk in the sub-bucket of the blob
+func chunkKey(id []byte, index uint64) []byte {
+	output := make([]byte, blobIDSize+8)
+	copy(output, id)
+	binary.BigEndian.PutUint64(output[blobIDSize:], index)
+	return output
+}
+
+// The blobBinding function returns the binding of a chunk, or of the header if the id is nil. The trailer
+// after the binding of the key has a fixed size, thus the binding of a blob never equals the one of another
+// key. The values of the blobs are also flagged with flagBlob, so that they cannot be read as a record
+func blobBinding(bucket string, k, id []byte, index uint64, last bool) []byte {
+	binding := bindTo(bucket, k)
+	trailer := make([]byte, blobTrailerSize)
+	if id == nil {
+		index = ^uint64(0)
+	}
+	copy(trailer, id)
+	binary.BigEndian.PutUint64(trailer[blobIDSize:], index)
+	if last {
+		trailer[blobTrailerSize-1] = 1
+	}
+	return append(binding, trailer...)
+}
+
+// The sealChunk function returns the chunk stored in bolt, which is encrypted if the secret is set
+func sealChunk(keys *keyring, bucket string, k, id []byte, index uint64, last bool, chunk []byte) ([]byte, error) {
+	if keys == nil {
+		return append([]byte(nil), chunk...), nil
+	}
+	return sealValue(keys.primary, chunk, blobBinding(bucket, k, id, index, last), flagBlob)
+}
+
+// The openChunk function returns the decrypted chunk, it is a copy of the value stored in bolt
+func openChunk(keys *keyring, bucket string, k, id []byte, index uint64, last bool, v []byte) ([]byte, error) {
+	chunk, err := openBlobValue(keys, v, blobBinding(bucket, k, id, index, last))
+	if err != nil {
+		return nil, decryptError(bucket, k, err)
+	}
+	return chunk, nil
+}
+
+// The openBlobValue function returns a copy of the value of a blob stored in bolt, which is decrypted if the
+// secret is set. The values without the flagBlob are not accepted, unless they are in plain text while a
+// Rekey encrypts the db
+func openBlobValue(keys *keyring, v, binding []byte) ([]byte, error) {
+	data := append([]byte(nil), v...)
+	if keys == nil {
+		return data, nil
+	}
+	if env, err := parseEnvelope(data); err == nil && env.version == 0 && keys.plaintext {
+		return data, nil
+	}
+
+	data, flags, err := openValue(keys, data, binding)
+	if err == nil && flags&flagBlob == 0 {
+		return nil, ErrTampered
+	}
+	return data, err
+}
+
+// The seal function returns the header stored in bolt, which is encrypted if the secret is set
+func (h *blobHeader) seal(keys *keyring, bucket string, k []byte) ([]byte, error) {
+	output := make([]byte, blobHeaderSize)
+	copy(output, h.id)
+	binary.BigEndian.PutUint32(output[blobIDSize:], uint32(h.chunkSize))
+	binary.BigEndian.PutUint64(output[blobIDSize+4:], uint64(h.length))
+
+	if keys == nil {
+		return output, nil
+	}
+	return sealValue(keys.primary, output, blobBinding(bucket, k, nil, 0, false), flagBlob)
+}
+
+// The openBlobHeader function returns the header of the blob stored in bolt
+func openBlobHeader(keys *keyring, bucket string, k, v []byte) (h blobHeader, err error) {
+	data, err := openBlobValue(keys, v, blobBinding(bucket, k, nil, 0, false))
+	if err != nil {
+		return h, decryptError(bucket, k, err)
+	}
+
+	if len(data) != blobHeaderSize {
+		return h, decryptError(bucket, k, ErrUnknownFormat)
+	}
+	h.id = data[:blobIDSize]
+	h.chunkSize = int64(binary.BigEndian.Uint32(data[blobIDSize:]))
+	h.length = int64(binary.BigEndian.Uint64(data[blobIDSize+4:]))
+	if h.chunkSize <= 0 || h.length < 0 {
+		return h, decryptError(bucket, k, ErrUnknownFormat)
+	}
+	return h, nil
+}
+
+// The rekeyBlob function re-encrypts the header and the chunks of the blob in the sub-bucket with the primary
+// cryptor, the values already encrypted with it are skipped. It returns true if the blob is rewritten
+func rekeyBlob(keys *keyring, primary Cryptor, bucket string, k []byte, sub *bolt.Bucket) (bool, error) {
+	v := sub.Get(blobHeaderKey)
+	if v == nil {
+		//an incomplete blob, which is replaced by the next PutReader
+		return false, nil
+	}
+
+	if env, err := parseEnvelope(v); err == nil && env.version == formatEnvelope && env.suite == primary.Suite() && env.keyID == primary.KeyID() {
+		return false, nil
+	}
+
+	h, err := openBlobHeader(keys, bucket, k, v)
+	if err != nil {
+		return false, err
+	}
+
+	newKeys := newKeyring(primary)
+	sealed := make([]record, 0)
+	enc, err := h.seal(newKeys, bucket, k)
+	if err != nil {
+		return false, err
+	}
+	sealed = append(sealed, record{key: blobHeaderKey, value: enc})
+
+	last := uint64(h.length / h.chunkSize)
+	for index := uint64(0); index <= last; index++ {
+		ck := chunkKey(h.id, index)
+		v := sub.Get(ck)
+		if v == nil {
+			return false, ErrTampered
+		}
+
+		chunk, err := openChunk(keys, bucket, k, h.id, index, index == last, v)
+		if err != nil {
+			return false, err
+		}
+		if enc, err = sealChunk(newKeys, bucket, k, h.id, index, index == last, chunk); err != nil {
+			return false, err
+		}
+		sealed = append(sealed, record{key: ck, value: enc})
+	}
+
+	for _, r := range sealed {
+		if err := sub.Put(r.key, r.value); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
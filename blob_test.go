@@ -0,0 +1,363 @@
+package boltsec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDBMBlob(t *testing.T) {
+	defer func(size int) { BlobChunkSize = size }(BlobChunkSize)
+	BlobChunkSize = 1024
+
+	for _, secret := range []string{"", "secret"} {
+		dir := t.TempDir()
+		dbm, err := NewDBManager("test.dat", dir, secret, false, []string{"attachment"})
+		if err != nil {
+			t.Fatalf("NewDBManager return err: %s", err)
+		}
+
+		for _, size := range []int{0, 100, 2048, 2500} {
+			data := make([]byte, size)
+			rand.Read(data)
+
+			n, err := dbm.PutReader("attachment", "file", bytes.NewReader(data))
+			if err != nil || n != int64(size) {
+				t.Fatalf("PutReader return %d, err: %v, expect: %d", n, err, size)
+			}
+
+			var buf bytes.Buffer
+			if n, err = dbm.GetWriter("attachment", "file", &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("GetWriter of %d bytes return %d, err: %v", size, n, err)
+			}
+		}
+
+		r, err := dbm.GetReader("attachment", "file")
+		if err != nil {
+			t.Fatalf("GetReader return err: %s", err)
+		}
+		var data bytes.Buffer
+		io.Copy(&data, r)
+
+		if pos, err := r.Seek(-600, io.SeekEnd); err != nil || pos != 1900 {
+			t.Fatalf("Seek return %d, err: %v", pos, err)
+		}
+		part := make([]byte, 300)
+		if _, err = io.ReadFull(r, part); err != nil || !bytes.Equal(part, data.Bytes()[1900:2200]) {
+			t.Errorf("ReadFull after Seek return err: %v", err)
+		}
+
+		//the blob is not a record
+		if keys, err := dbm.GetKeyList("attachment", ""); err != nil || len(keys) != 0 {
+			t.Errorf("GetKeyList return %v, err: %v", keys, err)
+		}
+
+		if _, err = dbm.PutReader("attachment", "file", bytes.NewReader([]byte("replaced"))); err != nil {
+			t.Fatalf("PutReader return err: %s", err)
+		}
+		if _, err = r.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek return err: %s", err)
+		}
+		if _, err = r.Read(part); err != ErrBlobChanged {
+			t.Errorf("Read of replaced blob return err: %v, expect: %v", err, ErrBlobChanged)
+		}
+
+		if err = dbm.Delete("attachment", "file"); err != nil {
+			t.Fatalf("Delete return err: %s", err)
+		}
+		if _, err = dbm.GetReader("attachment", "file"); err != ErrBlobNotFound {
+			t.Errorf("GetReader of deleted blob return err: %v, expect: %v", err, ErrBlobNotFound)
+		}
+
+		//the reserved buckets cannot be changed or read as blobs
+		if _, err = dbm.PutReader(metaBucket, canaryRecord, bytes.NewReader(data.Bytes())); err != ErrBucketReserved {
+			t.Errorf("PutReader to reserved bucket return err: %v, expect: %v", err, ErrBucketReserved)
+		}
+		if _, err = dbm.GetWriter(metaBucket, canaryRecord, io.Discard); err != ErrBucketReserved {
+			t.Errorf("GetWriter of reserved bucket return err: %v, expect: %v", err, ErrBucketReserved)
+		}
+	}
+
+	//the chunks have the default size if BlobChunkSize is not valid
+	BlobChunkSize = 0
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"attachment"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	data := make([]byte, defaultBlobChunkSize+100)
+	rand.Read(data)
+	if n, err := dbm.PutReader("attachment", "file", bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("PutReader with BlobChunkSize 0 return %d, err: %v", n, err)
+	}
+	var buf bytes.Buffer
+	if _, err = dbm.GetWriter("attachment", "file", &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetWriter with BlobChunkSize 0 return %d bytes, err: %v", buf.Len(), err)
+	}
+}
+
+func TestDBMBlobTampered(t *testing.T) {
+	defer func(size int) { BlobChunkSize = size }(BlobChunkSize)
+	BlobChunkSize = 1024
+
+	var err error
+	dir := t.TempDir()
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{"attachment"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := make([]byte, 3000)
+	rand.Read(data)
+	for _, key := range []string{"swapped", "truncated", "moved"} {
+		if _, err = dbm.PutReader("attachment", key, bytes.NewReader(data)); err != nil {
+			t.Fatalf("PutReader return err: %s", err)
+		}
+	}
+
+	//the blobs are rekeyed with the values
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err = dbm.GetWriter("attachment", "moved", &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("GetWriter after Rekey return err: %v", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("attachment"))
+
+		chunks := func(name string) (keys [][]byte, sub *bolt.Bucket) {
+			sub = bkt.Bucket([]byte(name))
+			sub.ForEach(func(k, v []byte) error {
+				if len(k) != len(blobHeaderKey) {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			return
+		}
+
+		keys, sub := chunks("swapped")
+		first, second := append([]byte(nil), sub.Get(keys[0])...), append([]byte(nil), sub.Get(keys[1])...)
+		sub.Put(keys[0], second)
+		sub.Put(keys[1], first)
+
+		keys, sub = chunks("truncated")
+		sub.Delete(keys[len(keys)-1])
+
+		keys, sub = chunks("moved")
+		bkt.Put([]byte("record"), sub.Get(keys[0]))
+		return nil
+	})
+	db.Close()
+
+	for _, key := range []string{"swapped", "truncated"} {
+		if _, err = dbm.GetWriter("attachment", key, io.Discard); !errors.Is(err, ErrTampered) && err != ErrBlobChanged {
+			t.Errorf("GetWriter of %s blob return err: %v, expect: %v", key, err, ErrTampered)
+		}
+	}
+	if _, err = dbm.GetOne("attachment", "record"); !errors.Is(err, ErrTampered) {
+		t.Errorf("GetOne of a chunk return err: %v, expect: %v", err, ErrTampered)
+	}
+}
+
+func TestDBMBlobReplaceRecord(t *testing.T) {
+	defer func(size int) { BlobChunkSize = size }(BlobChunkSize)
+	BlobChunkSize = 16
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"attachment"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	record := Article{ID: "file", Title: "input with more than 16 characters"}
+	if err = dbm.Save("attachment", "file", record); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//the record is kept if the PutReader fails after some of the chunks are written
+	data := make([]byte, BlobChunkSize*blobBatchChunks*3)
+	rand.Read(data)
+	failed := errors.New("failed")
+	if _, err = dbm.PutReader("attachment", "file", io.MultiReader(bytes.NewReader(data), &errReader{failed})); err != failed {
+		t.Errorf("PutReader return err: %v, expect: %v", err, failed)
+	}
+	if res, err := dbm.Get("attachment", "file"); err != nil || !bytes.Contains(res, []byte(record.Title)) {
+		t.Errorf("Get record after failed PutReader return %s, err: %v", res, err)
+	}
+	if n := countStaged(t, dbm); n != 0 {
+		t.Errorf("failed PutReader left %d staged blobs", n)
+	}
+
+	if _, err = dbm.PutReader("attachment", "file", bytes.NewReader(data)); err != nil {
+		t.Fatalf("PutReader return err: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err = dbm.GetWriter("attachment", "file", &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetWriter of blob replacing the record return err: %v", err)
+	}
+	if _, err = dbm.Get("attachment", "file"); err != ErrNotFound {
+		t.Errorf("Get replaced record return err: %v, expect: %v", err, ErrNotFound)
+	}
+	if n := countStaged(t, dbm); n != 0 {
+		t.Errorf("PutReader left %d staged blobs", n)
+	}
+}
+
+func TestDBMBlobConcurrent(t *testing.T) {
+	defer func(size int) { BlobChunkSize = size }(BlobChunkSize)
+	BlobChunkSize = 16
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", true, []string{"attachment"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	defer dbm.Close()
+
+	//a short PutReader of the same key completes while a long one is writing its chunks
+	long, short := make([]byte, BlobChunkSize*blobBatchChunks*20), []byte("short blob")
+	rand.Read(long)
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := dbm.PutReader("attachment", "file", io.MultiReader(bytes.NewReader(long[:BlobChunkSize*blobBatchChunks*2]), &signalReader{started}, bytes.NewReader(long[BlobChunkSize*blobBatchChunks*2:])))
+		done <- err
+	}()
+	<-started
+	if _, err = dbm.PutReader("attachment", "file", bytes.NewReader(short)); err != nil {
+		t.Fatalf("PutReader of short blob return err: %s", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("PutReader of long blob return err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = dbm.GetWriter("attachment", "file", &buf); err != nil || !(bytes.Equal(buf.Bytes(), long) || bytes.Equal(buf.Bytes(), short)) {
+		t.Fatalf("GetWriter after concurrent PutReader return %d bytes, err: %v", buf.Len(), err)
+	}
+
+	//only the chunks of the last blob and its header are left
+	chunks := (int64(buf.Len()) / int64(BlobChunkSize)) + 1
+	count := func(tx *boltsecTx) error {
+		if n := tx.Bucket([]byte("attachment")).Bucket([]byte("file")).Stats().KeyN; int64(n) != chunks+1 {
+			t.Errorf("blob has %d keys, expect: %d", n, chunks+1)
+		}
+		return nil
+	}
+	if err = dbm.openDB(); err != nil {
+		t.Fatalf("openDB return err: %s", err)
+	}
+	defer dbm.closeDB()
+	if err = dbm.db.view(count); err != nil {
+		t.Fatalf("view return err: %s", err)
+	}
+}
+
+func TestDBMBlobRekey(t *testing.T) {
+	defer func(size int) { BlobChunkSize = size }(BlobChunkSize)
+	BlobChunkSize = 16
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"attachment"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	//the Rekey is started while the PutReader is writing its third batch of chunks
+	data := make([]byte, BlobChunkSize*blobBatchChunks*4)
+	rand.Read(data)
+	half := BlobChunkSize * blobBatchChunks * 2
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := dbm.PutReader("attachment", "file", io.MultiReader(bytes.NewReader(data[:half]),
+			&blockReader{started, release}, bytes.NewReader(data[half:])))
+		done <- err
+	}()
+	<-started
+
+	rekeyed := make(chan error)
+	go func() {
+		rekeyed <- dbm.Rekey("secret", "new", nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err = <-done; err != nil {
+		t.Fatalf("PutReader return err: %s", err)
+	}
+	if err = <-rekeyed; err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = dbm.GetWriter("attachment", "file", &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetWriter after Rekey return %d bytes, err: %v", buf.Len(), err)
+	}
+}
+
+// The errReader struct returns the error on every Read
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// The signalReader struct closes the channel on the first Read and reads nothing
+type signalReader struct {
+	c chan struct{}
+}
+
+func (r *signalReader) Read(p []byte) (int, error) {
+	if r.c != nil {
+		close(r.c)
+		r.c = nil
+	}
+	return 0, io.EOF
+}
+
+// The blockReader struct closes the started channel on the first Read and waits until the release channel
+// is closed, then reads nothing
+type blockReader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	if r.started != nil {
+		close(r.started)
+		r.started = nil
+	}
+	<-r.release
+	return 0, io.EOF
+}
+
+// The countStaged function returns the number of the blobs staged in the metadata bucket
+func countStaged(t *testing.T, dbm *DBManager) (n int) {
+	if err := dbm.openDB(); err != nil {
+		t.Fatalf("openDB return err: %s", err)
+	}
+	defer dbm.closeDB()
+
+	count := func(tx *boltsecTx) error {
+		return tx.Bucket([]byte(metaBucket)).ForEach(func(k, v []byte) error {
+			if v == nil && bytes.HasPrefix(k, []byte(blobStagingPrefix)) {
+				n++
+			}
+			return nil
+		})
+	}
+	if err := dbm.db.view(count); err != nil {
+		t.Fatalf("view return err: %s", err)
+	}
+	return
+}
@@ -5,13 +5,15 @@
 package boltsec
 
 import (
-	"bytes"
-	"encoding/json"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	bolt "go.etcd.io/bbolt"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 type boltsecDB struct {
@@ -24,16 +26,50 @@ type boltsecTx struct {
 
 // The DBManager struct, all fields are not needed to be accessed by other packages
 type DBManager struct {
-	name      string
-	path      string
-	fullPath  string
-	secret    string
-	buckets   []string
-	batchMode bool
-	cryptor   *aesCryptor
-	db        *boltsecDB
+	name                 string
+	path                 string
+	fullPath             string
+	secret               []byte
+	shares               [][]byte
+	buckets              []string
+	batchMode            bool
+	kdfParams            *KDFParams
+	cryptor              Cryptor
+	suite                Suite
+	noLegacy             bool
+	keyProvider          KeyProvider
+	keyEncryption        bool
+	keyEncryptionBuckets []string
+	padding              Padding
+	codec                Codec
+	bucketCodecs         map[string]Codec
+	compression          bool
+	compressionLevel     int
+	indexes              map[string][]string
+	integrity            bool
+	integrityBuckets     []string
+	bucketConfigs        map[string]*bucketConfig
+	keys                 *keyring
+	bucketKeys           map[string]*keyring
+	keysMu               sync.RWMutex
+	locked               bool
+	rekeyMu              sync.RWMutex
+	db                   *boltsecDB
+	dbRefs               int
+	dbMu                 sync.Mutex
+	dbIdle               *sync.Cond
+	compacting           bool
 }
 
+// The Option type is to set the optional configurations of the DBManager in NewDBManager
+type Option func(*DBManager)
+
+// The name of the reserved bucket which keeps the metadata of the db, such as the KDF settings
+const metaBucket = "__boltsec_meta"
+
+// The size of the transactions which copy the records to the compacted db file
+const compactTxSize = 64 << 20
+
 var Debug = false
 var Logger = log.New(os.Stdout, "[DB] ", log.LstdFlags)
 
@@ -41,16 +77,73 @@ var Logger = log.New(os.Stdout, "[DB] ", log.LstdFlags)
 var (
 	ErrFileNameInvalid = errors.New("invalid file name")
 	ErrPathInvalid     = errors.New("invalid path name")
+	ErrBucketReserved  = errors.New("bucket name is reserved")
 	ErrKeyInvalid      = errors.New("invalid key or key is nil")
+	ErrSecretInvalid   = errors.New("invalid secret or secret is nil")
+	ErrWrongSecret     = errors.New("wrong secret, the db is encrypted with another secret")
+	ErrTampered        = errors.New("value authentication failed, the value is tampered or encrypted with another secret")
+	ErrUnknownKey      = errors.New("value is encrypted with an unknown key")
+	ErrUnknownSuite    = errors.New("value is encrypted with an unknown cipher suite")
+	ErrUnknownFormat   = errors.New("value has an unknown envelope format")
+	ErrKDFInvalid      = errors.New("invalid kdf parameters")
+	ErrShredded        = errors.New("value is encrypted with the key of a shredded tenant")
+	ErrTenantInvalid   = errors.New("invalid tenant or tenant is nil")
+	ErrSharesInvalid   = errors.New("invalid shares, the shares are not of the same secret")
+	ErrLocked          = errors.New("db is locked, the keys are removed from the memory until Unlock")
+	ErrBlobNotFound    = fmt.Errorf("blob %w", ErrNotFound)
+	ErrBlobChanged     = errors.New("blob is changed or deleted while it is read")
+	ErrNotIndexed      = errors.New("field is not indexed")
+	ErrUnknownCodec    = errors.New("value is encoded with an unknown codec")
+	ErrCodecChanged    = errors.New("codec of a plain text bucket cannot be changed once it has values")
+	ErrNotFound        = errors.New("not found")
+	ErrBucketNotFound  = fmt.Errorf("%w, it is not given to NewDBManager", bolt.ErrBucketNotFound)
+	ErrDecrypt         = errors.New("decrypt error from db")
+	ErrEncrypt         = errors.New("encrypt error from db")
 )
 
+// The DecryptError struct is returned by the read paths when a stored value cannot be decrypted or decoded,
+// errors.Is matches it with ErrDecrypt and with its cause, e.g. ErrTampered for a corrupt value or
+// ErrUnknownKey for a value encrypted with another secret
+type DecryptError struct {
+	Bucket string
+	// Key is the key of the record, it is the stored key if the keys of the bucket are encrypted
+	Key string
+	Err error
+}
+
+// The Error function returns the message of the error with the bucket and the key
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("cannot decrypt the value of key %q in bucket %s: %s", e.Key, e.Bucket, e.Err)
+}
+
+// The Unwrap function returns the cause of the error
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// The Is function returns true if the target is ErrDecrypt
+func (e *DecryptError) Is(target error) bool {
+	return target == ErrDecrypt
+}
+
+// The decryptError function returns the DecryptError of the stored key, the err is returned as it is if it is
+// nil or a DecryptError already
+func decryptError(bucket string, k []byte, err error) error {
+	var de *DecryptError
+	if err == nil || errors.As(err, &de) {
+		return err
+	}
+	return &DecryptError{Bucket: bucket, Key: string(k), Err: err}
+}
+
 // The main function to initialize the the DB manager for all DB related operations
 // 	name: the db file name, such as mydb.dat, mytest.db
 // 	path: the db file's path, can be "" or any other director
 // 	secret: the secret value if you want to encrypt the values; if you don't want to encrypt the data, simply put it as ""
 // 	batchMode: to control whether to close the db file after each db operation
 // 	buckets: the buckets in the db file to be initialized if the db file does not existed
-func NewDBManager(name, path, secret string, batchMode bool, buckets []string) (dbm *DBManager, err error) {
+// 	opts: the optional configurations, such as WithKDF, WithCryptor, WithKeyEncryption, WithBucketSecret or WithIndex
+func NewDBManager(name, path, secret string, batchMode bool, buckets []string, opts ...Option) (dbm *DBManager, err error) {
 	var info os.FileInfo
 	if path != "" {
 		info, err = os.Stat(path)
@@ -81,29 +174,272 @@ func NewDBManager(name, path, secret string, batchMode bool, buckets []string) (
 		buckets:   buckets,
 	}
 
-	if err = dbm.SetSecret(secret); err != nil {
+	for _, opt := range opts {
+		opt(dbm)
+	}
+
+	for _, bname := range buckets {
+		if isReserved(bname) {
+			err = ErrBucketReserved
+			return
+		}
+	}
+	for bname := range dbm.bucketConfigs {
+		if isReserved(bname) {
+			err = ErrBucketReserved
+			return
+		}
+	}
+
+	if dbm.kdfParams != nil {
+		if err = dbm.kdfParams.validate(); err != nil {
+			return
+		}
+	}
+
+	switch dbm.suite {
+	case 0:
+		dbm.suite = SuiteAESGCM
+	case SuiteAESGCM, SuiteXChaCha20Poly1305:
+	default:
+		err = ErrUnknownSuite
 		return
 	}
 
+	//the secret can be given as the argument, the shares or the bytes, but only one of them
+	key := []byte(secret)
+	if dbm.shares != nil || dbm.secret != nil {
+		if secret != "" || (dbm.shares != nil && dbm.secret != nil) {
+			err = ErrSecretInvalid
+			return
+		}
+		if key = dbm.secret; dbm.shares != nil {
+			if key, err = combineShares(dbm.shares...); err != nil {
+				return
+			}
+		}
+		dbm.shares, dbm.secret = nil, nil
+	}
+	defer zero(key)
+
 	if err = dbm.openDB(); err != nil {
 		return
 	}
 	defer dbm.closeDB()
 
+	if err = dbm.Unlock(key); err != nil {
+		return
+	}
+	if err = dbm.checkKeyEncryption(); err != nil {
+		return
+	}
+	err = dbm.checkCodecs()
 	return
 }
 
 // SetSecret is to set the AES Cryptor key, if the key is nil, the cryptor is not initialized; otherwise
-// the cryptor is initialized, including the key and Cipher block that can be used directly for encrypt and decrypt functions
+// the cryptor is initialized, including the key and Cipher block that can be used directly for encrypt and decrypt functions.
+// The buckets with their own secret or cryptor set by WithBucketSecret or WithBucketCryptor are not affected.
+// The key is derived with the KDF settings stored in the db if there are any. ErrWrongSecret is returned and
+// the secret in use is kept if the db is encrypted with another secret, or if the secret is "" and the db
+// is encrypted. ErrLocked is returned if the db is locked, see Unlock.
 func (dbm *DBManager) SetSecret(secret string) (err error) {
+	return dbm.setSecret([]byte(secret))
+}
+
+// The setSecret function sets the secret given as bytes, the DBManager keeps the slice
+func (dbm *DBManager) setSecret(secret []byte) (err error) {
+	dbm.rekeyMu.Lock()
+	defer dbm.rekeyMu.Unlock()
+
+	if _, err = dbm.currentKeys(); err != nil {
+		return err
+	}
+
+	var keys *keyring
+	if keys, err = dbm.buildKeys(secret); err != nil {
+		return err
+	}
+	if err = dbm.prepareKeys(keys, ""); err != nil {
+		return err
+	}
+
+	dbm.keysMu.Lock()
+	defer dbm.keysMu.Unlock()
+
+	if dbm.locked {
+		return ErrLocked
+	}
 	dbm.secret = secret
-	if secret == "" {
-		dbm.cryptor = nil
+	dbm.keys = keys
+	return nil
+}
+
+// The prepareKeys function verifies the keyring of the db, or of the bucket if it is not "", with the canary
+// and loads the random keys of the db which are encrypted with the keyring, including the data keys of the
+// tenants. A nil keyring is only valid if the db, or the bucket, has no canary, i.e. it is not encrypted.
+func (dbm *DBManager) prepareKeys(keys *keyring, bucket string) (err error) {
+	if keys == nil {
+		return dbm.verifyPlain(scopedRecord(bucket, canaryRecord))
+	}
+
+	if err = dbm.verifyKeys(keys, bucket); err != nil {
+		return
+	}
+
+	if bucket == "" {
+		if err = dbm.loadTenants(keys); err != nil {
+			return
+		}
+	}
+	return dbm.loadMetaKeys(keys, bucket)
+}
+
+// The loadMetaKeys function loads the random keys of the options into the keyring of the db, or of the
+// bucket if it is not "", the keys which don't exist yet are created
+func (dbm *DBManager) loadMetaKeys(keys *keyring, bucket string) (err error) {
+	if dbm.keyEncryption {
+		if keys.keyHash, err = dbm.loadMetaKey(keys, scopedRecord(bucket, keyHashRecord)); err != nil {
+			return
+		}
+	}
+	if len(dbm.indexes) > 0 {
+		if keys.indexKey, err = dbm.loadMetaKey(keys, scopedRecord(bucket, indexKeyRecord)); err != nil {
+			return
+		}
+	}
+	if dbm.integrity {
+		if keys.integrityKey, err = dbm.loadMetaKey(keys, scopedRecord(bucket, integrityKeyRecord)); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// The buildKeys function returns the keyring for the secret, the data key of the KeyProvider and the cryptor
+// set by WithCryptor; the cryptor is the primary one if it is set, then the data key. Nil is returned if
+// there is none of them. It is called with the rekeyMu held, as the KeyProvider is changed by RewrapKey
+func (dbm *DBManager) buildKeys(secret []byte) (keys *keyring, err error) {
+	if len(secret) != 0 {
+		if keys, err = dbm.deriveKeys(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	if dbm.keyProvider != nil {
+		dataKey, err := dbm.loadDataKey()
+		if err != nil {
+			return nil, err
+		}
+
+		dk, err := dbm.newSuiteKeyring(nil, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if keys != nil {
+			dk.merge(keys)
+		}
+		keys = dk
+	}
+
+	if dbm.cryptor != nil {
+		ck := newKeyring(dbm.cryptor)
+		if keys != nil {
+			ck.merge(keys)
+		}
+		keys = ck
+	}
+
+	if keys != nil {
+		if keys.noLegacy, err = dbm.rejectsLegacy(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// The deriveKeys function returns the keyring for the secret. If the db uses the KDF, the primary cryptor
+// uses the derived key and the sha256 key of the earlier versions is kept to read the existing values
+func (dbm *DBManager) deriveKeys(secret []byte) (*keyring, error) {
+	data := sha256.Sum256(secret)
+	legacy, err := dbm.newSuiteKeyring(secret, data[0:])
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := dbm.loadKDF()
+	if err != nil || settings == nil {
+		return legacy, err
+	}
+
+	key, err := settings.deriveKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := dbm.newSuiteKeyring(secret, key)
+	if err != nil {
+		return nil, err
+	}
+
+	keys.merge(legacy)
+	return keys, nil
+}
+
+// The newSuiteKeyring function returns the keyring with the cryptors of all the suites provided by the
+// package for the key, the primary cryptor is the one of the suite selected by WithSuite
+func (dbm *DBManager) newSuiteKeyring(secret, key []byte) (*keyring, error) {
+	ac, err := newAESCryptorWithKey(secret, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cc, err := newChaChaCryptor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys *keyring
+	if dbm.suite == SuiteXChaCha20Poly1305 {
+		keys = newKeyring(cc)
+		keys.legacy = ac
 	} else {
-		dbm.cryptor, err = newAESCryptor([]byte(secret))
+		keys = newKeyring(ac)
 	}
+	keys.add(ac)
+	keys.add(cc)
+	return keys, nil
+}
+
+// The setKeys function swaps in the keyring used by all the db operations, the keyring
+// is nil if the secret is not set
+func (dbm *DBManager) setKeys(secret []byte, keys *keyring) {
+	dbm.keysMu.Lock()
+	defer dbm.keysMu.Unlock()
 
-	return err
+	dbm.secret = secret
+	dbm.keys = keys
+}
+
+// The isSecret function returns true if the secret is the one in use
+func (dbm *DBManager) isSecret(secret []byte) bool {
+	dbm.keysMu.RLock()
+	defer dbm.keysMu.RUnlock()
+
+	return subtle.ConstantTimeCompare(dbm.secret, secret) == 1
+}
+
+// The currentKeys function returns the keyring in use, the returned keyring is not
+// changed by the SetSecret or Rekey functions, so it can be used for a whole transaction.
+// ErrLocked is returned if the db is locked
+func (dbm *DBManager) currentKeys() (*keyring, error) {
+	dbm.keysMu.RLock()
+	defer dbm.keysMu.RUnlock()
+
+	if dbm.locked {
+		return nil, ErrLocked
+	}
+	return dbm.keys, nil
 }
 
 // SetBatchMode is to set the batchMode for the boltdb. The boltdb file is always open in the file system unless the Close() is called.
@@ -111,16 +447,30 @@ func (dbm *DBManager) SetSecret(secret string) (err error) {
 // set to false, the db will be closed after each db operation, this could reduce a certain performance. Thus if you have a lots of db
 // operations to execute, you can set the batchMode to be true before those operations.
 func (dbm *DBManager) SetBatchMode(mode bool) {
+	dbm.dbMu.Lock()
+	defer dbm.dbMu.Unlock()
+
 	dbm.batchMode = mode
-	//if the batch mode is turned off, close DB directly
-	if !mode {
-		dbm.closeDB()
+	//if the batch mode is turned off, close DB directly unless it is used by other operations
+	if !mode && dbm.dbRefs == 0 && dbm.db != nil {
+		dbm.db.Close()
+		dbm.db = nil
 	}
 }
 
-// This function creates the db file if it doesn't exist, and also initialize the buckets
+// This function creates the db file if it doesn't exist, and also initialize the buckets.
+// The db is shared by the operations running at the same time, each openDB call must be
+// paired with a closeDB call
 func (dbm *DBManager) openDB() (err error) {
-	if dbm.batchMode && dbm.db != nil {
+	dbm.dbMu.Lock()
+	defer dbm.dbMu.Unlock()
+
+	for dbm.compacting {
+		dbm.idleCond().Wait()
+	}
+
+	if dbm.db != nil {
+		dbm.dbRefs++
 		return
 	}
 
@@ -132,11 +482,17 @@ func (dbm *DBManager) openDB() (err error) {
 	db := &boltsecDB{d}
 
 	initbuckets := func(tx *boltsecTx) error {
-		for _, bname := range dbm.buckets {
+		//a new db file has no bucket, thus no values of the earlier versions
+		first, _ := tx.Cursor().First()
+		created := first == nil
+		for _, bname := range append([]string{metaBucket}, dbm.buckets...) {
 			if _, err := tx.CreateBucketIfNotExists([]byte(bname)); err != nil {
 				return err
 			}
 		}
+		if created {
+			return tx.Bucket([]byte(metaBucket)).Put([]byte(noLegacyRecord), []byte{1})
+		}
 		return nil
 	}
 
@@ -146,17 +502,99 @@ func (dbm *DBManager) openDB() (err error) {
 	}
 
 	dbm.db = db
+	dbm.dbRefs = 1
 	return
 }
 
-// The closeDB function closes the db when the dbm.db is not nil and the batchmode is false.
+// The closeDB function closes the db when the dbm.db is not nil, the batchmode is false and
+// no other operation is using the db.
 // When the dbm batchmode is true, please set it to be false in order to close the DB.
 func (dbm *DBManager) closeDB() {
-	if !dbm.batchMode && dbm.db != nil {
+	dbm.dbMu.Lock()
+	defer dbm.dbMu.Unlock()
+
+	if dbm.dbRefs > 0 {
+		dbm.dbRefs--
+	}
+	if !dbm.batchMode && dbm.dbRefs == 0 && dbm.db != nil {
 		dbm.db.Close()
 		dbm.db = nil
 	}
+	dbm.idleCond().Broadcast()
+
+	return
+}
+
+// The idleCond function returns the condition signaled when an operation releases the db, or a compaction
+// is done. The dbMu must be locked by the caller
+func (dbm *DBManager) idleCond() *sync.Cond {
+	if dbm.dbIdle == nil {
+		dbm.dbIdle = sync.NewCond(&dbm.dbMu)
+	}
+	return dbm.dbIdle
+}
+
+// Compact rewrites the db file with only the records in use, and replaces the file with it. The deleted
+// values stay in the free pages of the file until they are reused, the Compact removes them, e.g. after
+// a ShredTenant or a Rekey. The operations started during the Compact wait for it, and it waits for the
+// operations already running, thus it must not be called in the function of Update or View.
+func (dbm *DBManager) Compact() (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	return dbm.compactDB()
+}
+
+// The compactDB function compacts the db file opened by the caller, it waits until the caller is the only
+// operation using the db
+func (dbm *DBManager) compactDB() (err error) {
+	dbm.dbMu.Lock()
+	defer dbm.dbMu.Unlock()
+
+	for dbm.compacting {
+		dbm.idleCond().Wait()
+	}
+	dbm.compacting = true
+	defer func() {
+		dbm.compacting = false
+		dbm.idleCond().Broadcast()
+	}()
+	for dbm.dbRefs > 1 {
+		dbm.idleCond().Wait()
+	}
+
+	tmpPath := dbm.fullPath + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		return
+	}
+	if err = bolt.Compact(dst, dbm.db.DB, compactTxSize); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return
+	}
 
+	dbm.db.Close()
+	err = os.Rename(tmpPath, dbm.fullPath)
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	//the db is opened again for the caller, the compacted file or the original one if it cannot be replaced
+	d, openErr := bolt.Open(dbm.fullPath, 0600, nil)
+	if openErr != nil {
+		dbm.db = nil
+		dbm.dbRefs = 0
+		return openErr
+	}
+	dbm.db = &boltsecDB{d}
 	return
 }
 
@@ -176,6 +614,34 @@ func (db *boltsecDB) update(fn func(*boltsecTx) error) error {
 	return db.DB.Update(wrapper)
 }
 
+// The batch function applies changes to the database in a transaction shared with the concurrent calls,
+// the function can be called more than once.
+func (db *boltsecDB) batch(fn func(*boltsecTx) error) error {
+	wrapper := func(tx *bolt.Tx) error {
+		return fn(&boltsecTx{tx})
+	}
+	return db.DB.Batch(wrapper)
+}
+
+// The decryptValue function returns a copy of the stored value, which is decrypted if the secret is set, and
+// the flags of its envelope header. The value returned by bolt is only valid in the transaction, thus it is always copied.
+// The errors are returned as a DecryptError
+func decryptValue(keys *keyring, bucket string, k, v []byte) ([]byte, byte, error) {
+	content := make([]byte, len(v))
+	copy(content, v)
+
+	if keys == nil {
+		return content, 0, nil
+	}
+
+	//secret key is set, decrypt the content before return
+	dec, flags, err := openValue(keys, content, bindTo(bucket, k))
+	if err != nil {
+		return nil, 0, decryptError(bucket, k, err)
+	}
+	return dec, flags, nil
+}
+
 // The GetByPrefix function returns the byte arrays for those records matched with specified Prefix. If the secret is set,
 // the function returns the decrypted content.
 func (dbm *DBManager) GetByPrefix(bucket, prefix string) ([][]byte, error) {
@@ -188,33 +654,16 @@ func (dbm *DBManager) GetByPrefix(bucket, prefix string) ([][]byte, error) {
 
 	results = make([][]byte, 0)
 
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return nil, err
+	}
 	seekPrefix := func(tx *boltsecTx) error {
-		prefixKey := []byte(prefix)
-
-		bkt := tx.Bucket([]byte(bucket))
-
-		if bkt == nil {
-			return bolt.ErrBucketNotFound
+		records, err := dbm.seekRecords(tx, keys, bucket, []byte(prefix), 0, false)
+		for _, r := range records {
+			results = append(results, r.value)
 		}
-
-		cursor := bkt.Cursor()
-		for k, v := cursor.Seek(prefixKey); bytes.HasPrefix(k, prefixKey); k, v = cursor.Next() {
-
-			if dbm.cryptor != nil {
-				//secret key is set, decrypt the content before return
-				content := make([]byte, len(v))
-				copy(content, v)
-
-				dec, err := dbm.cryptor.decrypt(content)
-				if err != nil {
-					return errors.New("Decrypt error from db")
-				}
-				results = append(results, dec)
-			} else {
-				results = append(results, v)
-			}
-		}
-		return nil
+		return err
 	}
 
 	if err = dbm.db.view(seekPrefix); err != nil {
@@ -235,20 +684,16 @@ func (dbm *DBManager) GetKeyList(bucket, prefix string) ([]string, error) {
 
 	results = make([]string, 0)
 
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return nil, err
+	}
 	seekPrefix := func(tx *boltsecTx) error {
-		prefixKey := []byte(prefix)
-
-		bkt := tx.Bucket([]byte(bucket))
-
-		if bkt == nil {
-			return bolt.ErrBucketNotFound
-		}
-
-		cursor := bkt.Cursor()
-		for k, _ := cursor.Seek(prefixKey); k != nil && bytes.HasPrefix(k, prefixKey); k, _ = cursor.Next() {
-			results = append(results, string(k))
+		records, err := dbm.seekRecords(tx, keys, bucket, []byte(prefix), 0, true)
+		for _, r := range records {
+			results = append(results, string(r.key))
 		}
-		return nil
+		return err
 	}
 
 	if err = dbm.db.view(seekPrefix); err != nil {
@@ -258,9 +703,20 @@ func (dbm *DBManager) GetKeyList(bucket, prefix string) ([]string, error) {
 	return results, err
 }
 
-// The GetOne function returns the first record containing the key, If the secret is set,
-// the function returns the decrypted content.
-func (dbm *DBManager) GetOne(bucket, key string) ([]byte, error) {
+// The Get function returns the record of the key, If the secret is set, the function returns the
+// decrypted content. ErrNotFound is returned if there is no record of the key.
+func (dbm *DBManager) Get(bucket, key string) ([]byte, error) {
+	r, err := dbm.getRecord(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return r.value, nil
+}
+
+// The GetFirstByPrefix function returns the first record whose key starts with the prefix, in the order
+// of the keys, If the secret is set, the function returns the decrypted content. ErrNotFound is returned
+// if no key starts with the prefix.
+func (dbm *DBManager) GetFirstByPrefix(bucket, prefix string) ([]byte, error) {
 	var err error
 	var result []byte
 
@@ -269,38 +725,25 @@ func (dbm *DBManager) GetOne(bucket, key string) ([]byte, error) {
 	}
 	defer dbm.closeDB()
 
-	if key == "" {
+	if prefix == "" {
 		return nil, ErrKeyInvalid
 	}
 
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return nil, err
+	}
 	seek := func(tx *boltsecTx) error {
-		prefixKey := []byte(key)
-
-		bkt := tx.Bucket([]byte(bucket))
-
-		if bkt == nil {
-			return bolt.ErrBucketNotFound
+		records, err := dbm.seekRecords(tx, keys, bucket, []byte(prefix), 1, false)
+		if err != nil {
+			return err
 		}
 
-		cursor := bkt.Cursor()
-		k, v := cursor.Seek(prefixKey)
-
-		content := make([]byte, len(v))
-		copy(content, v)
-
-		if k != nil && bytes.HasPrefix(k, prefixKey) {
-			if dbm.cryptor != nil {
-				//secret key is set, decrypt the content before return
-				dec, err := dbm.cryptor.decrypt(content)
-				if err != nil {
-					return errors.New("Decrypt error from db")
-				}
-				result = dec
-			} else {
-				result = content
-			}
+		if len(records) == 0 {
+			return ErrNotFound
 		}
 
+		result = records[0].value
 		return nil
 	}
 
@@ -311,70 +754,30 @@ func (dbm *DBManager) GetOne(bucket, key string) ([]byte, error) {
 	return result, nil
 }
 
-// The Save function stores the record into the db file. If the secret value is set, the function
-// encrypts the content before storing into the db.
-func (dbm *DBManager) Save(bucket, key string, data interface{}) error {
-	var err error
-
-	if err = dbm.openDB(); err != nil {
-		return err
-	}
-	defer dbm.closeDB()
-
-	if data == nil {
-		return errors.New("data is nil")
-	}
-
-	save := func(tx *boltsecTx) error {
-		var err error
-		bkt := tx.Bucket([]byte(bucket))
-
-		value, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-		if dbm.cryptor != nil {
-			//encrypt the content before store in the db
-			enc, err := dbm.cryptor.encrypt(value)
-			if err != nil {
-				return errors.New("Decrypt error from db")
-			}
-
-			if err = bkt.Put([]byte(key), enc); err != nil {
-				return err
-			}
-		} else {
-			if err = bkt.Put([]byte(key), value); err != nil {
-				return err
-			}
-		}
+// The GetOne function returns the first record containing the key, the same as GetFirstByPrefix.
+//
+// Deprecated: GetOne returns the record of another key if the key doesn't exist, e.g. "a-10" for "a-1",
+// use Get to read the record of the key, or GetFirstByPrefix.
+func (dbm *DBManager) GetOne(bucket, key string) ([]byte, error) {
+	return dbm.GetFirstByPrefix(bucket, key)
+}
 
-		return nil
+// The Save function stores the record into the db file. The data is encoded with the codec of the bucket,
+// JSON by default, see WithCodec. If the secret value is set, the function encrypts the content before
+// storing into the db.
+func (dbm *DBManager) Save(bucket, key string, data interface{}) error {
+	save := func(tx *Tx) error {
+		return tx.Put(bucket, key, data)
 	}
 
-	return dbm.db.update(save)
+	return dbm.Update(save)
 }
 
-// The Delete function deletes the record specified by the key.
+// The Delete function deletes the record specified by the key, or the blob stored by PutReader.
 func (dbm *DBManager) Delete(bucket, key string) error {
-	var err error
-
-	if err = dbm.openDB(); err != nil {
-		return err
-	}
-	defer dbm.closeDB()
-
-	if key == "" {
-		return errors.New("cannot delete, key is nil")
-	}
-
-	delete := func(tx *boltsecTx) error {
-		bkt := tx.Bucket([]byte(bucket))
-		if err := bkt.Delete([]byte(key)); err != nil {
-			return err
-		}
-		return nil
+	delete := func(tx *Tx) error {
+		return tx.Delete(bucket, key)
 	}
 
-	return dbm.db.update(delete)
+	return dbm.Update(delete)
 }
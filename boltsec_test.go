@@ -1,7 +1,11 @@
 package boltsec
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
 	"testing"
 )
 
@@ -67,6 +71,155 @@ func TestDBMCreate(t *testing.T) {
 	return
 }
 
+func TestDBMTampered(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{
+		ID:    "ID-0001",
+		Title: "input with more than 16 characters",
+	}
+
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		value := append([]byte(nil), bkt.Get([]byte(data.ID))...)
+		value[len(value)-1] ^= 0x01
+		return bkt.Put([]byte(data.ID), value)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("tamper value return err: %s", err)
+	}
+
+	if _, err = dbm.GetOne(bucketName, data.ID); !errors.Is(err, ErrTampered) {
+		t.Errorf("GetOne return err: %v, expect: %v", err, ErrTampered)
+	}
+
+	if _, err = dbm.GetByPrefix(bucketName, "ID-"); !errors.Is(err, ErrTampered) {
+		t.Errorf("GetByPrefix return err: %v, expect: %v", err, ErrTampered)
+	}
+
+	var de *DecryptError
+	if _, err = dbm.GetOne(bucketName, data.ID); !errors.As(err, &de) || de.Bucket != bucketName || de.Key != data.ID || !errors.Is(err, ErrDecrypt) {
+		t.Errorf("GetOne return err: %v, expect a DecryptError of %s", err, data.ID)
+	}
+}
+
+func TestDBMNotFound(t *testing.T) {
+	var err error
+	bucketName := "article"
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	if _, err = dbm.GetOne(bucketName, "ID-0001"); err != ErrNotFound {
+		t.Errorf("GetOne of a missing record return err: %v, expect: %v", err, ErrNotFound)
+	}
+	if _, err = dbm.GetReader(bucketName, "ID-0001"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetReader of a missing blob return err: %v, expect: %v", err, ErrNotFound)
+	}
+
+	if _, err = dbm.GetOne("missing", "ID-0001"); !errors.Is(err, ErrBucketNotFound) || !errors.Is(err, bolt.ErrBucketNotFound) {
+		t.Errorf("GetOne of a missing bucket return err: %v, expect: %v", err, ErrBucketNotFound)
+	}
+	if err = dbm.Save("missing", "ID-0001", Article{}); err != ErrBucketNotFound {
+		t.Errorf("Save to a missing bucket return err: %v, expect: %v", err, ErrBucketNotFound)
+	}
+	if err = dbm.Delete("missing", "ID-0001"); err != ErrBucketNotFound {
+		t.Errorf("Delete from a missing bucket return err: %v, expect: %v", err, ErrBucketNotFound)
+	}
+}
+
+func TestDBMSwapped(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName, "other"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "a-123", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		value := append([]byte(nil), tx.Bucket([]byte(bucketName)).Get([]byte(data.ID))...)
+		if err := tx.Bucket([]byte(bucketName)).Put([]byte("a-456"), value); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("other")).Put([]byte(data.ID), value)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("copy value return err: %s", err)
+	}
+
+	if _, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Errorf("GetOne original value return err: %s", err)
+	}
+	if _, err = dbm.GetOne(bucketName, "a-456"); !errors.Is(err, ErrTampered) {
+		t.Errorf("GetOne value copied to another key return err: %v, expect: %v", err, ErrTampered)
+	}
+	if _, err = dbm.GetOne("other", data.ID); !errors.Is(err, ErrTampered) {
+		t.Errorf("GetOne value copied to another bucket return err: %v, expect: %v", err, ErrTampered)
+	}
+}
+
+func TestDBMGet(t *testing.T) {
+	bucketName := "article"
+
+	for _, opts := range [][]Option{nil, {WithKeyEncryption()}} {
+		dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{bucketName}, opts...)
+		if err != nil {
+			t.Fatalf("NewDBManager return err: %s", err)
+		}
+
+		data := Article{ID: "a-10", Title: "input with more than 16 characters"}
+		if err = dbm.Save(bucketName, data.ID, data); err != nil {
+			t.Fatalf("save data return err: %s", err)
+		}
+
+		if res, err := dbm.Get(bucketName, data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+			t.Errorf("Get return %s, err: %v", res, err)
+		}
+		if _, err = dbm.Get(bucketName, "a-1"); err != ErrNotFound {
+			t.Errorf("Get of a prefix return err: %v, expect: %v", err, ErrNotFound)
+		}
+
+		for _, get := range []func(string, string) ([]byte, error){dbm.GetFirstByPrefix, dbm.GetOne} {
+			if res, err := get(bucketName, "a-1"); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+				t.Errorf("GetFirstByPrefix return %s, err: %v", res, err)
+			}
+			if _, err = get(bucketName, "b-"); err != ErrNotFound {
+				t.Errorf("GetFirstByPrefix of a missing prefix return err: %v, expect: %v", err, ErrNotFound)
+			}
+		}
+	}
+}
+
 func BenchmarkDBMOps(b *testing.B) {
 	var err error
 	bucketName := "article"
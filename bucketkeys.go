@@ -0,0 +1,97 @@
+package boltsec
+
+// The bucketConfig struct keeps the secret and the cryptor registered for a bucket
+type bucketConfig struct {
+	secret  []byte
+	cryptor Cryptor
+}
+
+// WithBucketSecret sets the secret of the bucket instead of the secret of the db, the values of the bucket
+// are not encrypted if the secret is "". It can be given for several buckets, e.g. to keep the data of each
+// tenant under a different secret and the shared reference data in plain text. The bucket secrets are
+// verified by NewDBManager the same as the secret of the db, and are not changed by SetSecret and Rekey.
+func WithBucketSecret(bucket, secret string) Option {
+	return func(dbm *DBManager) {
+		dbm.bucketConfig(bucket).secret = []byte(secret)
+	}
+}
+
+// WithBucketCryptor sets the Cryptor of the bucket instead of the secret of the db. If WithBucketSecret
+// is also given for the bucket, the values encrypted with the bucket secret are still readable, the same
+// as WithCryptor for the db.
+func WithBucketCryptor(bucket string, cryptor Cryptor) Option {
+	return func(dbm *DBManager) {
+		dbm.bucketConfig(bucket).cryptor = cryptor
+	}
+}
+
+// The bucketConfig function returns the config of the bucket, which is created if it doesn't exist
+func (dbm *DBManager) bucketConfig(bucket string) *bucketConfig {
+	if dbm.bucketConfigs == nil {
+		dbm.bucketConfigs = make(map[string]*bucketConfig)
+	}
+	if _, ok := dbm.bucketConfigs[bucket]; !ok {
+		dbm.bucketConfigs[bucket] = new(bucketConfig)
+	}
+	return dbm.bucketConfigs[bucket]
+}
+
+// The scopedRecord function returns the name of the metadata record for the bucket which has its own
+// secret or cryptor, the bucket is "" for the record of the db
+func scopedRecord(bucket, name string) string {
+	if bucket == "" {
+		return name
+	}
+	return "bucket/" + bucket + "/" + name
+}
+
+// The buildBucketKeys function builds and verifies the keyrings of the buckets registered by WithBucketSecret
+// and WithBucketCryptor
+func (dbm *DBManager) buildBucketKeys() (bucketKeys map[string]*keyring, err error) {
+	bucketKeys = make(map[string]*keyring)
+
+	for bucket, config := range dbm.bucketConfigs {
+		var keys *keyring
+		if len(config.secret) != 0 {
+			//the keyring zeroes its key when it is closed, the secret is kept for Unlock
+			if keys, err = dbm.deriveKeys(append([]byte(nil), config.secret...)); err != nil {
+				return nil, err
+			}
+		}
+
+		if config.cryptor != nil {
+			ck := newKeyring(config.cryptor)
+			if keys != nil {
+				ck.merge(keys)
+			}
+			keys = ck
+		}
+
+		if keys != nil {
+			if keys.noLegacy, err = dbm.rejectsLegacy(); err != nil {
+				return nil, err
+			}
+		}
+		if err = dbm.prepareKeys(keys, bucket); err != nil {
+			return nil, err
+		}
+		bucketKeys[bucket] = keys
+	}
+	return bucketKeys, nil
+}
+
+// The keysFor function returns the keyring of the bucket, which is the keyring of the db unless the
+// bucket has its own secret or cryptor. Nil is returned if the values of the bucket are not encrypted,
+// and ErrLocked if the db is locked
+func (dbm *DBManager) keysFor(bucket string) (*keyring, error) {
+	dbm.keysMu.RLock()
+	defer dbm.keysMu.RUnlock()
+
+	if dbm.locked {
+		return nil, ErrLocked
+	}
+	if keys, ok := dbm.bucketKeys[bucket]; ok {
+		return keys, nil
+	}
+	return dbm.keys, nil
+}
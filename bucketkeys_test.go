@@ -0,0 +1,80 @@
+package boltsec
+
+import (
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBMBucketSecret(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "tenant", "shared"}
+	opts := []Option{WithBucketSecret("tenant", "tenant-secret"), WithBucketSecret("shared", "")}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	for _, bucket := range buckets {
+		if err = dbm.Save(bucket, data.ID, data); err != nil {
+			t.Fatalf("save data to %s return err: %s", bucket, err)
+		}
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		resNew := new(Article)
+		if err := json.Unmarshal(tx.Bucket([]byte("shared")).Get([]byte(data.ID)), resNew); err != nil {
+			t.Errorf("value of the shared bucket is encrypted: %s", err)
+		}
+		return nil
+	})
+	db.Close()
+
+	if _, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithBucketSecret("tenant", "wrong")); err != ErrWrongSecret {
+		t.Errorf("NewDBManager with wrong bucket secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+
+	//the tenant bucket is not readable with the secret of the db
+	other, err := NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if _, err = other.GetOne("tenant", data.ID); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("GetOne tenant value with the db secret return err: %v, expect: %v", err, ErrUnknownKey)
+	}
+
+	//the Rekey of the db secret leaves the buckets with their own secret
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "new", false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	for _, bucket := range buckets {
+		var bytes []byte
+		if bytes, err = dbm.GetOne(bucket, data.ID); err != nil {
+			t.Errorf("GetOne from %s return err: %s", bucket, err)
+			continue
+		}
+
+		resNew := new(Article)
+		if err = json.Unmarshal(bytes, resNew); err != nil {
+			t.Errorf("json.Unmarshal return err: %s", err)
+		}
+		if resNew.Title != data.Title {
+			t.Errorf("returned Title is not equal: new:%s, org: %s", resNew.Title, data.Title)
+		}
+	}
+}
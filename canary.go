@@ -0,0 +1,153 @@
+package boltsec
+
+import (
+	"bytes"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The name of the metadata record which keeps the canary, it is the canaryValue encrypted with the
+// secret of the db and is used to detect a wrong secret before any value is read or written
+const canaryRecord = "canary"
+
+var canaryValue = []byte("boltsec canary")
+
+// The number of the authenticated values which probeKeys tries to decrypt before the secret is taken as wrong
+const canaryProbes = 16
+
+// The verifyKeys function checks the keyring of the db, or of the bucket if it is not "", with the canary
+// record, ErrWrongSecret is returned if the canary cannot be decrypted. The canary is written with the
+// primary cryptor if the db doesn't have one yet, i.e. the db is new or was created by an earlier version,
+// once the keyring is checked with the values of the db, see probeKeys.
+func (dbm *DBManager) verifyKeys(keys *keyring, bucket string) (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	name := scopedRecord(bucket, canaryRecord)
+	var canary []byte
+	load := func(tx *boltsecTx) error {
+		if v := tx.Bucket([]byte(metaBucket)).Get([]byte(name)); v != nil {
+			canary = append([]byte(nil), v...)
+			return nil
+		}
+		return dbm.probeKeys(tx, keys, bucket)
+	}
+
+	if err = dbm.db.view(load); err != nil {
+		return
+	}
+
+	if canary == nil {
+		return dbm.writeCanary(keys.primary, name, false)
+	}
+
+	dec, _, err := openValue(keys, canary, bindTo(metaBucket, []byte(name)))
+	if err != nil || !bytes.Equal(dec, canaryValue) {
+		return ErrWrongSecret
+	}
+	return nil
+}
+
+// The verifyPlain function returns ErrWrongSecret if the canary record exists, i.e. the db or the bucket
+// is encrypted but no secret is given
+func (dbm *DBManager) verifyPlain(name string) (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	load := func(tx *boltsecTx) error {
+		if tx.Bucket([]byte(metaBucket)).Get([]byte(name)) != nil {
+			return ErrWrongSecret
+		}
+		return nil
+	}
+
+	return dbm.db.view(load)
+}
+
+// The probeKeys function checks the keyring of a db, or of a bucket, which has no canary. Nil is returned
+// if the db has no values, or if one of its authenticated values is decrypted by the keyring, the keys of
+// the metadata bucket are tried first. ErrWrongSecret is returned otherwise, including when the db has
+// only the values which are not authenticated, i.e. the plain text or the AES-CFB values, as any secret
+// decrypts them into garbage.
+func (dbm *DBManager) probeKeys(tx *boltsecTx, keys *keyring, bucket string) error {
+	found, probes := false, 0
+	probe := func(v []byte, binding []byte) bool {
+		found = true
+		if env, err := parseEnvelope(v); err != nil || env.version == 0 {
+			return false
+		}
+
+		probes++
+		_, _, err := openValue(keys, append([]byte(nil), v...), binding)
+		return err == nil
+	}
+
+	prefix := []byte(scopedRecord(bucket, metaKeyPrefix))
+	cursor := tx.Bucket([]byte(metaBucket)).Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		if probe(v, bindTo(metaBucket, k)) {
+			return nil
+		}
+	}
+
+	names := []string{bucket}
+	if bucket == "" {
+		names = names[:0]
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if _, ok := dbm.bucketConfigs[string(name)]; !ok && !isReserved(string(name)) {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	}
+
+	for _, name := range names {
+		bkt := tx.Bucket([]byte(name))
+		if bkt == nil {
+			continue
+		}
+
+		cursor := bkt.Cursor()
+		for k, v := cursor.First(); k != nil && probes < canaryProbes; k, v = cursor.Next() {
+			//the blobs are skipped, their chunks are bound to the blob
+			if v == nil {
+				continue
+			}
+			if probe(v, bindTo(name, k)) {
+				return nil
+			}
+		}
+	}
+
+	if found {
+		return ErrWrongSecret
+	}
+	return nil
+}
+
+// The writeCanary function stores the canary encrypted with the cryptor into the record, the existing
+// canary is kept unless overwrite is true
+func (dbm *DBManager) writeCanary(c Cryptor, name string, overwrite bool) (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	write := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		if !overwrite && bkt.Get([]byte(name)) != nil {
+			return nil
+		}
+
+		enc, err := sealValue(c, canaryValue, bindTo(metaBucket, []byte(name)), 0)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(name), enc)
+	}
+
+	return dbm.db.update(write)
+}
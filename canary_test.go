@@ -0,0 +1,141 @@
+package boltsec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDBMWrongSecret(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "other", false, []string{bucketName}); err != ErrWrongSecret {
+		t.Errorf("NewDBManager with wrong secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+
+	if err = dbm.SetSecret("other"); err != ErrWrongSecret {
+		t.Errorf("SetSecret with wrong secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if _, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Errorf("GetOne after wrong SetSecret return err: %s", err)
+	}
+
+	if err = dbm.Rekey("secret", "other", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "secret", false, []string{bucketName}); err != ErrWrongSecret {
+		t.Errorf("NewDBManager with old secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if _, err = NewDBManager("test.dat", dir, "other", false, []string{bucketName}); err != nil {
+		t.Errorf("NewDBManager with new secret return err: %s", err)
+	}
+}
+
+func TestDBMMissingSecret(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "private"}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets, WithBucketSecret("private", "bucket"))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if err = dbm.Save("article", "ID-0001", Article{ID: "ID-0001"}); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//an encrypted db or bucket cannot be opened without its secret, the values would be read as plain text
+	if _, err = NewDBManager("test.dat", dir, "", false, buckets, WithBucketSecret("private", "bucket")); err != ErrWrongSecret {
+		t.Errorf("NewDBManager without secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if _, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithBucketSecret("private", "")); err != ErrWrongSecret {
+		t.Errorf("NewDBManager without bucket secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if err = dbm.SetSecret(""); err != ErrWrongSecret {
+		t.Errorf("SetSecret without secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if err = dbm.Close(); err != nil {
+		t.Fatalf("Close return err: %s", err)
+	}
+	if err = dbm.Unlock(nil); err != ErrWrongSecret {
+		t.Errorf("Unlock without secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if err = dbm.Unlock([]byte("secret")); err != nil {
+		t.Errorf("Unlock return err: %s", err)
+	}
+
+	//a db without secret is still opened without secret
+	if _, err = NewDBManager("plain.dat", dir, "", false, buckets); err != nil {
+		t.Fatalf("NewDBManager plain text db return err: %s", err)
+	}
+	if _, err = NewDBManager("plain.dat", dir, "", false, buckets); err != nil {
+		t.Errorf("NewDBManager plain text db again return err: %s", err)
+	}
+}
+
+func TestDBMCanaryEarlierVersion(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article"}
+
+	dbm, err := NewDBManager("test.dat", dir, "right", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save("article", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//the db of an earlier version has no canary, the secret is checked with its values
+	drop := func(tx *boltsecTx) error {
+		return tx.Bucket([]byte(metaBucket)).Delete([]byte(canaryRecord))
+	}
+	if err = dbm.openDB(); err != nil {
+		t.Fatalf("openDB return err: %s", err)
+	}
+	err = dbm.db.update(drop)
+	dbm.closeDB()
+	if err != nil {
+		t.Fatalf("delete canary return err: %s", err)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "typo", false, buckets); err != ErrWrongSecret {
+		t.Errorf("NewDBManager with wrong secret and no canary return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if _, err = NewDBManager("test.dat", dir, "right", false, buckets); err != nil {
+		t.Fatalf("NewDBManager with right secret and no canary return err: %s", err)
+	}
+	if _, err = NewDBManager("test.dat", dir, "typo", false, buckets); err != ErrWrongSecret {
+		t.Errorf("NewDBManager with wrong secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+
+	//the plain text values cannot be read with a secret
+	if dbm, err = NewDBManager("plain.dat", dir, "", false, buckets); err != nil {
+		t.Fatalf("NewDBManager plain text db return err: %s", err)
+	}
+	if err = dbm.Save("article", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+	if _, err = NewDBManager("plain.dat", dir, "secret", false, buckets); err != ErrWrongSecret {
+		t.Errorf("NewDBManager plain text db with secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if dbm, err = NewDBManager("plain.dat", dir, "", false, buckets); err != nil {
+		t.Fatalf("NewDBManager plain text db again return err: %s", err)
+	}
+	if res, err := dbm.Get("article", data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+		t.Errorf("Get plain text value return %s, err: %v", res, err)
+	}
+}
@@ -0,0 +1,84 @@
+package boltsec
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+)
+
+// The chachaCryptor struct is the XChaCha20-Poly1305 cryptor, which is faster than AES-GCM on
+// the CPUs without AES instructions, e.g. the small ARM boards. Its 24 bytes nonce is safe to be
+// generated randomly for any number of values.
+type chachaCryptor struct {
+	key  []byte
+	id   KeyID
+	aead cipher.AEAD
+}
+
+// The newChaChaCryptor return a pointer to the chachaCryptor struct with the 32 bytes key derived
+// from the secret. The key is not used directly, a subkey is derived from it so that the same key is
+// never shared by the AES and the XChaCha20 ciphers
+func newChaChaCryptor(key []byte) (result *chachaCryptor, err error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("boltsec xchacha20-poly1305"))
+
+	result = new(chachaCryptor)
+	result.key = mac.Sum(nil)
+	result.id = NewKeyID(result.key)
+
+	result.aead, err = chacha20poly1305.NewX(result.key)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// The Suite function returns SuiteXChaCha20Poly1305
+func (cc *chachaCryptor) Suite() Suite {
+	return SuiteXChaCha20Poly1305
+}
+
+// The KeyID function returns the identifier of the key
+func (cc *chachaCryptor) KeyID() KeyID {
+	return cc.id
+}
+
+// The Encrypt function encrypt the data with XChaCha20-Poly1305, the output is nonce || ciphertext || tag.
+// The additionalData is authenticated but not encrypted, and must be given again to decrypt
+func (cc *chachaCryptor) Encrypt(data, additionalData []byte) ([]byte, error) {
+	nonceSize := cc.aead.NonceSize()
+
+	output := make([]byte, nonceSize, nonceSize+len(data)+cc.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, output); err != nil {
+		return nil, err
+	}
+
+	return cc.aead.Seal(output, output, data, additionalData), nil
+}
+
+// The Decrypt function decrypt the data in place, ErrTampered is returned if the data or the
+// additionalData fails the authentication
+func (cc *chachaCryptor) Decrypt(data, additionalData []byte) ([]byte, error) {
+	nonceSize := cc.aead.NonceSize()
+	if len(data) < nonceSize+cc.aead.Overhead() {
+		return []byte(""), errors.New("cipherText too short")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	dec, err := cc.aead.Open(ciphertext[:0], nonce, ciphertext, additionalData)
+	if err != nil {
+		return []byte(""), ErrTampered
+	}
+	return dec, nil
+}
+
+// The Close function zeroes the key kept by the cryptor, the cryptor must not be used afterwards.
+// The copy of the key inside the cipher cannot be zeroed, it is released with the cryptor
+func (cc *chachaCryptor) Close() error {
+	zero(cc.key)
+	return nil
+}
@@ -0,0 +1,100 @@
+package boltsec
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestChaChaEnc(t *testing.T) {
+	content := []byte(`"{"info":{"name":"gXeMfp.zip","type":"","size":79448, "comment":"test"}}"`)
+	ad := []byte("additional data")
+
+	cc, err := newChaChaCryptor(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("newChaChaCryptor return err: %s", err)
+	}
+
+	enc, err := cc.Encrypt(content, ad)
+	if err != nil {
+		t.Fatalf("Unable to encrypt '%v': %v", content, err)
+	}
+
+	dec, err := cc.Decrypt(append([]byte(nil), enc...), ad)
+	if err != nil {
+		t.Fatalf("Unable to decrypt '%v': %v", enc, err)
+	}
+	if !bytes.Equal(dec, content) {
+		t.Errorf("Decrypt\n  Expect: %v\n  Actual: %v", content, dec)
+	}
+
+	if _, err = cc.Decrypt(append([]byte(nil), enc...), []byte("other data")); err != ErrTampered {
+		t.Errorf("Decrypt with other additional data return err: %v, expect: %v", err, ErrTampered)
+	}
+
+	enc[len(enc)-1] ^= 0x01
+	if _, err = cc.Decrypt(enc, ad); err != ErrTampered {
+		t.Errorf("Decrypt tampered data return err: %v, expect: %v", err, ErrTampered)
+	}
+}
+
+func TestDBMMixedSuites(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	suites := []Suite{SuiteAESGCM, SuiteXChaCha20Poly1305}
+	for i, suite := range suites {
+		dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName}, WithSuite(suite))
+		if err != nil {
+			t.Fatalf("NewDBManager with suite %d return err: %s", suite, err)
+		}
+
+		data := Article{ID: fmt.Sprintf("ID-%04d", i), Title: "input with more than 16 characters"}
+		if err = dbm.Save(bucketName, data.ID, data); err != nil {
+			t.Fatalf("save data return err: %s", err)
+		}
+	}
+
+	for _, suite := range suites {
+		dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName}, WithSuite(suite))
+		if err != nil {
+			t.Fatalf("NewDBManager with suite %d return err: %s", suite, err)
+		}
+
+		results, err := dbm.GetByPrefix(bucketName, "ID-")
+		if err != nil {
+			t.Errorf("GetByPrefix with suite %d return err: %s", suite, err)
+		}
+		if len(results) != len(suites) {
+			t.Errorf("GetByPrefix with suite %d return %d records", suite, len(results))
+		}
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "secret", false, []string{bucketName}, WithSuite(SuiteCustom)); err != ErrUnknownSuite {
+		t.Errorf("NewDBManager with unknown suite return err: %v, expect: %v", err, ErrUnknownSuite)
+	}
+}
+
+func BenchmarkReuseChaChaCryptor(b *testing.B) {
+	content := []byte(`"{"info":{"name":"gXeMfp.zip","type":"","size":79448, "comment":"test"}}"`)
+
+	cc, err := newChaChaCryptor(make([]byte, 32))
+	if err != nil {
+		b.Errorf("newChaChaCryptor return err: %s", err)
+	}
+
+	for n := 0; n < b.N; n++ {
+		enc, err := cc.Encrypt(content, nil)
+		if err != nil {
+			b.Errorf("Unable to encrypt '%v': %v", content, err)
+		}
+		dec, err := cc.Decrypt(enc, nil)
+		if err != nil {
+			b.Errorf("Unable to decrypt '%v': %v", enc, err)
+		}
+		if !bytes.Equal(dec, content) {
+			b.Errorf("Decrypt\n  Expect: %v\n  Actual: %v", content, dec)
+		}
+	}
+}
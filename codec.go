@@ -0,0 +1,236 @@
+package boltsec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Codec is the interface to encode the data given to Save and Put into the values stored in the db, and to
+// decode them for Store. The DBManager uses JSONCodec by default, another codec can be selected for the
+// db with WithCodec, or for a bucket with WithBucketCodec, e.g. GobCodec or RawCodec, or a codec of another
+// package such as msgpack, CBOR or protobuf.
+//
+// The ID of the codec is stored in every encrypted value, thus it must not change for the same encoding,
+// and Store decodes the value with the codec which encoded it. The plain text values have no room for it,
+// they are decoded with the codec of the bucket, thus NewDBManager returns ErrCodecChanged if the codec of
+// a bucket which is not encrypted is changed once the bucket has values.
+type Codec interface {
+	// ID returns the identifier of the codec
+	ID() CodecID
+	// Marshal returns the encoding of the data
+	Marshal(data interface{}) ([]byte, error)
+	// Unmarshal decodes the value into the data, which is a pointer
+	Unmarshal(value []byte, data interface{}) error
+}
+
+// CodecID identifies the Codec of a value
+type CodecID byte
+
+// The codecs provided by the package, the values from CodecCustom are reserved for the Codec
+// implementations of other packages
+const (
+	CodecJSON   CodecID = 1
+	CodecGob    CodecID = 2
+	CodecRaw    CodecID = 3
+	CodecCustom CodecID = 128
+)
+
+// The codecs provided by the package
+var (
+	// JSONCodec encodes the data with encoding/json, it is the only codec whose values are indexed by WithIndex
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encodes the data with encoding/gob, the type of the data must be the same for the Unmarshal
+	GobCodec Codec = gobCodec{}
+	// RawCodec stores a []byte or a string as it is, e.g. the binary payloads which JSON encodes as base64
+	RawCodec Codec = rawCodec{}
+)
+
+// WithCodec sets the Codec of the values of the db instead of JSONCodec, see WithBucketCodec for a bucket.
+// The values encoded with the other codecs of the package or of the options stay readable by Store.
+func WithCodec(codec Codec) Option {
+	return func(dbm *DBManager) {
+		dbm.codec = codec
+	}
+}
+
+// WithBucketCodec sets the Codec of the values of the bucket instead of the codec of the db
+func WithBucketCodec(bucket string, codec Codec) Option {
+	return func(dbm *DBManager) {
+		if dbm.bucketCodecs == nil {
+			dbm.bucketCodecs = make(map[string]Codec)
+		}
+		dbm.bucketCodecs[bucket] = codec
+	}
+}
+
+// The codecFor function returns the Codec of the bucket
+func (dbm *DBManager) codecFor(bucket string) Codec {
+	if codec, ok := dbm.bucketCodecs[bucket]; ok {
+		return codec
+	}
+	if dbm.codec != nil {
+		return dbm.codec
+	}
+	return JSONCodec
+}
+
+// The lookupCodec function returns the Codec of the identifier, which is one of the package or of the
+// options. ErrUnknownCodec is returned if there is none
+func (dbm *DBManager) lookupCodec(id CodecID) (Codec, error) {
+	for _, codec := range []Codec{JSONCodec, GobCodec, RawCodec, dbm.codec} {
+		if codec != nil && codec.ID() == id {
+			return codec, nil
+		}
+	}
+	for _, codec := range dbm.bucketCodecs {
+		if codec.ID() == id {
+			return codec, nil
+		}
+	}
+	return nil, ErrUnknownCodec
+}
+
+// The prefix of the metadata records which keep the codec of the buckets which are not encrypted
+const codecRecordPrefix = "codec/"
+
+// The checkCodecs function records the codec of each bucket which is not encrypted, and returns
+// ErrCodecChanged if the bucket has values of another codec. The values of a bucket without the record
+// are the JSON of the earlier versions
+func (dbm *DBManager) checkCodecs() (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	check := func(tx *boltsecTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		for _, bucket := range dbm.buckets {
+			keys, err := dbm.keysFor(bucket)
+			if err != nil {
+				return err
+			}
+			bkt := tx.Bucket([]byte(bucket))
+			if keys != nil || bkt == nil {
+				continue
+			}
+
+			id := dbm.codecFor(bucket).ID()
+			name := []byte(codecRecordPrefix + bucket)
+			v := meta.Get(name)
+			current := CodecJSON
+			if len(v) == 1 {
+				current = CodecID(v[0])
+			}
+			if current != id && hasValues(bkt) {
+				return ErrCodecChanged
+			}
+
+			if len(v) != 1 || current != id {
+				if err = meta.Put(name, []byte{byte(id)}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return dbm.db.update(check)
+}
+
+// The hasValues function returns true if the bucket has a record, the blobs are not records
+func hasValues(bkt *bolt.Bucket) bool {
+	cursor := bkt.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if v != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// The tagCodec function prefixes the value with the identifier of its codec
+func tagCodec(id CodecID, value []byte) []byte {
+	output := make([]byte, 1, 1+len(value))
+	output[0] = byte(id)
+	return append(output, value...)
+}
+
+// The untagCodec function splits the value prefixed by the tagCodec function
+func untagCodec(data []byte) (CodecID, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, ErrUnknownFormat
+	}
+	return CodecID(data[0]), data[1:], nil
+}
+
+type jsonCodec struct{}
+
+// The ID function returns CodecJSON
+func (jsonCodec) ID() CodecID {
+	return CodecJSON
+}
+
+// The Marshal function returns the JSON of the data
+func (jsonCodec) Marshal(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// The Unmarshal function decodes the JSON value into the data
+func (jsonCodec) Unmarshal(value []byte, data interface{}) error {
+	return json.Unmarshal(value, data)
+}
+
+type gobCodec struct{}
+
+// The ID function returns CodecGob
+func (gobCodec) ID() CodecID {
+	return CodecGob
+}
+
+// The Marshal function returns the gob of the data
+func (gobCodec) Marshal(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The Unmarshal function decodes the gob value into the data
+func (gobCodec) Unmarshal(value []byte, data interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(value)).Decode(data)
+}
+
+type rawCodec struct{}
+
+// The ID function returns CodecRaw
+func (rawCodec) ID() CodecID {
+	return CodecRaw
+}
+
+// The Marshal function returns a copy of the []byte or the string
+func (rawCodec) Marshal(data interface{}) ([]byte, error) {
+	switch d := data.(type) {
+	case []byte:
+		return append([]byte{}, d...), nil
+	case string:
+		return []byte(d), nil
+	}
+	return nil, fmt.Errorf("raw codec cannot encode %T, only []byte or string", data)
+}
+
+// The Unmarshal function copies the value into the *[]byte or the *string
+func (rawCodec) Unmarshal(value []byte, data interface{}) error {
+	switch d := data.(type) {
+	case *[]byte:
+		*d = append([]byte{}, value...)
+		return nil
+	case *string:
+		*d = string(value)
+		return nil
+	}
+	return fmt.Errorf("raw codec cannot decode into %T, only *[]byte or *string", data)
+}
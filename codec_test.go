@@ -0,0 +1,147 @@
+package boltsec
+
+import (
+	"bytes"
+	"testing"
+)
+
+type fakeCodec struct{}
+
+func (fakeCodec) ID() CodecID                              { return CodecCustom }
+func (fakeCodec) Marshal(data interface{}) ([]byte, error) { return JSONCodec.Marshal(data) }
+func (fakeCodec) Unmarshal(value []byte, data interface{}) error {
+	return JSONCodec.Unmarshal(value, data)
+}
+
+func TestDBMCodec(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "file", "plain"}
+	opts := []Option{
+		WithCodec(GobCodec),
+		WithBucketCodec("file", RawCodec),
+		WithBucketSecret("plain", ""),
+		WithBucketCodec("plain", GobCodec),
+	}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	for _, bucket := range []string{"article", "plain"} {
+		if err = NewStore[Article](dbm, bucket).Put(data.ID, data); err != nil {
+			t.Fatalf("Put to %s return err: %s", bucket, err)
+		}
+		if res, err := NewStore[Article](dbm, bucket).Get(data.ID); err != nil || res != data {
+			t.Errorf("Get from %s return %+v, err: %v", bucket, res, err)
+		}
+	}
+
+	payload := []byte{0x00, 0xff, 0x10}
+	if err = dbm.Save("file", "payload", payload); err != nil {
+		t.Fatalf("Save raw payload return err: %s", err)
+	}
+	if res, err := dbm.GetOne("file", "payload"); err != nil || !bytes.Equal(res, payload) {
+		t.Errorf("GetOne raw payload return %x, err: %v, expect: %x", res, err, payload)
+	}
+	if err = dbm.Save("file", "article", data); err == nil {
+		t.Errorf("Save a struct with RawCodec return no err")
+	}
+
+	//the codec is recorded in the values, thus the values saved with another codec stay readable
+	if dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithBucketSecret("plain", ""), WithBucketCodec("plain", GobCodec)); err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	data2 := Article{ID: "ID-0002", Title: "saved with JSON"}
+	store := NewStore[Article](dbm, "article")
+	if err = store.Put(data2.ID, data2); err != nil {
+		t.Fatalf("Put return err: %s", err)
+	}
+	if res, err := store.List("ID-"); err != nil || len(res) != 2 || res[0] != data || res[1] != data2 {
+		t.Errorf("List of mixed codecs return %+v, err: %v", res, err)
+	}
+	if res, err := NewStore[[]byte](dbm, "file").Get("payload"); err != nil || !bytes.Equal(res, payload) {
+		t.Errorf("Get raw payload return %x, err: %v", res, err)
+	}
+
+	//the values of the plain text bucket are decoded with the codec of the bucket
+	if res, err := NewStore[Article](dbm, "plain").Get(data.ID); err != nil || res != data {
+		t.Errorf("Get from plain return %+v, err: %v", res, err)
+	}
+
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if res, err := store.Get(data.ID); err != nil || res != data {
+		t.Errorf("Get after Rekey return %+v, err: %v", res, err)
+	}
+
+	//the values of a custom codec are not readable without it
+	if dbm, err = NewDBManager("test.dat", dir, "new", false, buckets, WithBucketCodec("file", fakeCodec{})); err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if err = dbm.Save("file", "custom", data); err != nil {
+		t.Fatalf("Save with custom codec return err: %s", err)
+	}
+	if res, err := NewStore[Article](dbm, "file").Get("custom"); err != nil || res != data {
+		t.Errorf("Get custom value return %+v, err: %v", res, err)
+	}
+	if dbm, err = NewDBManager("test.dat", dir, "new", false, buckets); err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if _, err = NewStore[Article](dbm, "file").Get("custom"); err != ErrUnknownCodec {
+		t.Errorf("Get value of unknown codec return err: %v, expect: %v", err, ErrUnknownCodec)
+	}
+}
+
+func TestDBMCodecPlainChanged(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"plain", "empty"}
+
+	dbm, err := NewDBManager("test.dat", dir, "", false, buckets, WithCodec(GobCodec))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save("plain", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//the plain text values have no codec identifier, thus the codec of the bucket cannot be changed
+	if _, err = NewDBManager("test.dat", dir, "", false, buckets); err != ErrCodecChanged {
+		t.Errorf("NewDBManager with another codec return err: %v, expect: %v", err, ErrCodecChanged)
+	}
+	if _, err = NewDBManager("test.dat", dir, "", false, buckets, WithBucketCodec("plain", JSONCodec), WithCodec(GobCodec)); err != ErrCodecChanged {
+		t.Errorf("NewDBManager with another bucket codec return err: %v, expect: %v", err, ErrCodecChanged)
+	}
+
+	//the codec of an empty bucket can be changed, here from GobCodec to JSONCodec
+	if dbm, err = NewDBManager("test.dat", dir, "", false, buckets, WithBucketCodec("plain", GobCodec)); err != nil {
+		t.Fatalf("NewDBManager with codec of empty bucket changed return err: %s", err)
+	}
+	if res, err := NewStore[Article](dbm, "plain").Get(data.ID); err != nil || res != data {
+		t.Errorf("Get from plain return %+v, err: %v", res, err)
+	}
+
+	//the values of a bucket which has no codec recorded are the JSON of the earlier versions
+	if err = dbm.Save("empty", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+	drop := func(tx *boltsecTx) error {
+		return tx.Bucket([]byte(metaBucket)).Delete([]byte(codecRecordPrefix + "empty"))
+	}
+	if err = dbm.openDB(); err != nil {
+		t.Fatalf("openDB return err: %s", err)
+	}
+	err = dbm.db.update(drop)
+	dbm.closeDB()
+	if err != nil {
+		t.Fatalf("delete codec record return err: %s", err)
+	}
+	if _, err = NewDBManager("test.dat", dir, "", false, buckets, WithBucketCodec("plain", GobCodec), WithBucketCodec("empty", GobCodec)); err != ErrCodecChanged {
+		t.Errorf("NewDBManager with codec of earlier version changed return err: %v, expect: %v", err, ErrCodecChanged)
+	}
+}
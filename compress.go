@@ -0,0 +1,47 @@
+package boltsec
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// WithCompression compresses the values with DEFLATE at the level, e.g. flate.DefaultCompression, before
+// they are encrypted by Save. A value is stored compressed only if it gets smaller, which is flagged in its
+// envelope header, thus the compressed and uncompressed values can be mixed in a bucket, and the values saved
+// before the option is set stay readable. The values are only compressed if they are encrypted.
+//
+// Be aware that the length of a compressed value depends on its content, if a part of the value can be
+// chosen by an attacker who can see the db file, the length can reveal whether the rest of the value
+// contains the chosen text. WithPadding reduces but doesn't remove this leak.
+func WithCompression(level int) Option {
+	return func(dbm *DBManager) {
+		dbm.compression = true
+		dbm.compressionLevel = level
+	}
+}
+
+// The compress function returns the value compressed with DEFLATE
+func compress(value []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(value); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The decompress function returns the value decompressed with DEFLATE
+func decompress(value []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,74 @@
+package boltsec
+
+import (
+	"bytes"
+	"compress/flate"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDBMCompression(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article"}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	long := Article{ID: "ID-0001", Title: strings.Repeat("verbose content of the article ", 100)}
+	if err = dbm.Save("article", long.ID, long); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithCompression(flate.BestCompression), WithPadding(PadPowerOfTwo))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	//the short value is stored uncompressed as it doesn't get smaller
+	articles := []Article{{ID: "ID-0002", Title: long.Title}, {ID: "ID-0003", Title: "s"}}
+	for _, a := range articles {
+		if err = dbm.Save("article", a.ID, a); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+
+	for _, a := range append(articles, long) {
+		res, err := dbm.GetOne("article", a.ID)
+		if err != nil || !bytes.Contains(res, []byte(a.Title)) {
+			t.Errorf("GetOne %s return %.40s, err: %v", a.ID, res, err)
+		}
+	}
+
+	//the Rekey keeps the values compressed
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if res, err := dbm.GetOne("article", "ID-0002"); err != nil || !bytes.Contains(res, []byte(long.Title)) {
+		t.Errorf("GetOne after Rekey return %.40s, err: %v", res, err)
+	}
+
+	dbm.SetBatchMode(false)
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	defer db.Close()
+
+	db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("article"))
+		if size, uncompressed := len(bkt.Get([]byte("ID-0002"))), len(bkt.Get([]byte("ID-0001"))); size >= uncompressed/4 {
+			t.Errorf("compressed value size %d, uncompressed value size %d", size, uncompressed)
+		}
+
+		env, err := parseEnvelope(bkt.Get([]byte("ID-0003")))
+		if err != nil || env.flags&flagCompressed != 0 || env.flags&flagPadded == 0 {
+			t.Errorf("short value flags %b, err: %v", env.flags, err)
+		}
+		return nil
+	})
+}
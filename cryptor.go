@@ -0,0 +1,67 @@
+package boltsec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Cryptor is the interface to encrypt and decrypt the values stored in the db. The DBManager uses the
+// AES-GCM cryptor derived from the secret by default, another implementation can be supplied with the
+// WithCryptor option, e.g. a FIPS validated cipher or a fake cryptor for the tests.
+//
+// The Suite and KeyID of the cryptor are stored in the envelope header of every value it encrypts, thus
+// they must not change for the same key, and the read paths use them to find the cryptor of a value.
+type Cryptor interface {
+	// Suite returns the identifier of the cipher suite
+	Suite() Suite
+	// KeyID returns the identifier of the key, which must not reveal the key, see NewKeyID
+	KeyID() KeyID
+	// Encrypt encrypts the plaintext and authenticates the additionalData with it
+	Encrypt(plaintext, additionalData []byte) ([]byte, error)
+	// Decrypt decrypts the ciphertext, ErrTampered is expected if the ciphertext or the additionalData fails
+	// the authentication. The ciphertext can be modified by the Decrypt, e.g. decrypted in place
+	Decrypt(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// Suite identifies the cipher suite of a Cryptor
+type Suite byte
+
+// The cipher suites provided by the package, the values from SuiteCustom are reserved for
+// the Cryptor implementations of other packages
+const (
+	SuiteAESGCM            Suite = 1
+	SuiteXChaCha20Poly1305 Suite = 2
+	SuiteCustom            Suite = 128
+)
+
+const keyIDSize = 8
+
+// KeyID identifies the key used to encrypt a value without revealing the key
+type KeyID [keyIDSize]byte
+
+// NewKeyID returns the KeyID for the key, it is a truncated HMAC so that the key
+// itself cannot be derived from the identifier
+func NewKeyID(key []byte) (id KeyID) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("boltsec key id"))
+	copy(id[:], mac.Sum(nil))
+	return
+}
+
+// WithCryptor sets the Cryptor to encrypt the values instead of the AES cryptor derived from the secret.
+// If the secret is also given, the values encrypted with the secret are still readable, and can be
+// re-encrypted with the cryptor by calling Rekey(secret, secret).
+func WithCryptor(cryptor Cryptor) Option {
+	return func(dbm *DBManager) {
+		dbm.cryptor = cryptor
+	}
+}
+
+// WithSuite selects the cipher suite to encrypt the values with the secret, SuiteAESGCM is the default.
+// The values of all the suites provided by the package are readable whatever suite is selected, thus
+// the suite of a db can be changed at any time, and Rekey(secret, secret) re-encrypts all the values with it.
+func WithSuite(suite Suite) Option {
+	return func(dbm *DBManager) {
+		dbm.suite = suite
+	}
+}
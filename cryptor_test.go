@@ -0,0 +1,128 @@
+package boltsec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+// The fakeCryptor is a Cryptor for the tests, it only flips the bits and checks the additionalData
+type fakeCryptor struct {
+	encrypted int
+}
+
+func (fc *fakeCryptor) Suite() Suite {
+	return SuiteCustom
+}
+
+func (fc *fakeCryptor) KeyID() KeyID {
+	return NewKeyID([]byte("fake"))
+}
+
+func (fc *fakeCryptor) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	fc.encrypted++
+	sum := sha256.Sum256(additionalData)
+	output := append([]byte(nil), sum[:]...)
+	for _, b := range plaintext {
+		output = append(output, ^b)
+	}
+	return output, nil
+}
+
+func (fc *fakeCryptor) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	sum := sha256.Sum256(additionalData)
+	if len(ciphertext) < len(sum) || !bytes.Equal(ciphertext[:len(sum)], sum[:]) {
+		return nil, ErrTampered
+	}
+	output := make([]byte, 0, len(ciphertext)-len(sum))
+	for _, b := range ciphertext[len(sum):] {
+		output = append(output, ^b)
+	}
+	return output, nil
+}
+
+func TestDBMWithCryptor(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+	fc := new(fakeCryptor)
+
+	dbm, err := NewDBManager("test.dat", dir, "", false, []string{bucketName}, WithCryptor(fc))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+	if fc.encrypted != 2 {
+		t.Errorf("the cryptor encrypted %d values, expect the canary and the saved value", fc.encrypted)
+	}
+
+	var bytes []byte
+	if bytes, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Fatalf("GetOne return err: %s", err)
+	}
+
+	resNew := new(Article)
+	if err = json.Unmarshal(bytes, resNew); err != nil {
+		t.Errorf("json.Unmarshal return err: %s", err)
+	}
+	if resNew.Title != data.Title {
+		t.Errorf("returned Title is not equal: new:%s, org: %s", resNew.Title, data.Title)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		env, err := parseEnvelope(tx.Bucket([]byte(bucketName)).Get([]byte(data.ID)))
+		if err != nil || env.suite != SuiteCustom || env.keyID != fc.KeyID() {
+			t.Errorf("parseEnvelope return suite: %d, keyID: %x, err: %v", env.suite, env.keyID, err)
+		}
+		return nil
+	})
+	db.Close()
+}
+
+func TestDBMWithCryptorMigrate(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, []string{bucketName}, WithCryptor(new(fakeCryptor)))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if _, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Errorf("GetOne value saved with the secret return err: %s", err)
+	}
+
+	if err = dbm.Rekey("secret", "secret", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	//only the cryptor is needed once all the values are re-encrypted
+	dbm, err = NewDBManager("test.dat", dir, "", false, []string{bucketName}, WithCryptor(new(fakeCryptor)))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if _, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Errorf("GetOne after Rekey return err: %s", err)
+	}
+}
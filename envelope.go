@@ -0,0 +1,222 @@
+package boltsec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Every value written by the DBManager with a secret is stored in an envelope, which starts with a
+// small self-describing header so that the read paths know how the value was produced:
+//
+//	magic(4) | version(1) | suite(1) | flags(1) | keyID(8) | body
+//
+// The header is authenticated as additional data of the cipher suite, thus it cannot be changed
+// without being detected. When the flagBound is set, the bucket name and the key of the value are
+// authenticated as well, so that the value cannot be copied to another key or bucket. Values without the valueMagic are treated as the legacy AES-CFB
+// format which is just IV || ciphertext.
+var valueMagic = []byte{0xb0, 0x17, 0x5e, 0xc0}
+
+const (
+	// formatGCM is the first authenticated format: magic || version || nonce || ciphertext || tag,
+	// it has neither cipher suite nor key identifier and is always AES-256-GCM
+	formatGCM byte = 1
+	// formatEnvelope is the format described above
+	formatEnvelope byte = 2
+
+	envelopeHeaderSize = 4 + 1 + 1 + 1 + keyIDSize
+)
+
+// The flags of the envelope header
+const (
+	// flagBound is set when the value is bound to its bucket and key
+	flagBound byte = 1 << iota
+	// flagKeyEmbedded is set when the key of the record is embedded in the value, see embedKey
+	flagKeyEmbedded
+	// flagPadded is set when the value is padded to hide its length, see WithPadding
+	flagPadded
+	// flagCompressed is set when the value is compressed, see WithCompression
+	flagCompressed
+	// flagBlob is set for the header and the chunks of a blob, see PutReader
+	flagBlob
+	// flagCodec is set when the value is prefixed by the identifier of its codec, see tagCodec
+	flagCodec
+
+	knownFlags = flagBound | flagKeyEmbedded | flagPadded | flagCompressed | flagBlob | flagCodec
+)
+
+// The name of the metadata record which marks the db without the values of the earlier versions, it is
+// written when the db is created and when a Rekey is completed
+const noLegacyRecord = "nolegacy"
+
+// WithoutLegacyFormat disables the read of the values written by the earlier versions, i.e. the AES-CFB
+// values without the envelope header and the values of the formatGCM, which are not bound to their key
+// and the first of which is not even authenticated. They are reported as ErrTampered, the same as the
+// values changed in the db file. It is only needed for a db created by an earlier version, the read of
+// these values is disabled without the option for the db created by this version, or once all its values
+// are upgraded by Rekey. Call Rekey(secret, secret) without this option first to upgrade the values of the
+// earlier versions, as the Rekey cannot read them either with it.
+func WithoutLegacyFormat() Option {
+	return func(dbm *DBManager) {
+		dbm.noLegacy = true
+	}
+}
+
+// The rejectsLegacy function returns true if the values of the earlier versions are rejected, as the
+// WithoutLegacyFormat is given or the db is marked by the noLegacyRecord
+func (dbm *DBManager) rejectsLegacy() (reject bool, err error) {
+	if dbm.noLegacy {
+		return true, nil
+	}
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	load := func(tx *boltsecTx) error {
+		reject = tx.Bucket([]byte(metaBucket)).Get([]byte(noLegacyRecord)) != nil
+		return nil
+	}
+	err = dbm.db.view(load)
+	return
+}
+
+// The markNoLegacy function writes the noLegacyRecord
+func (dbm *DBManager) markNoLegacy() (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	mark := func(tx *boltsecTx) error {
+		return tx.Bucket([]byte(metaBucket)).Put([]byte(noLegacyRecord), []byte{1})
+	}
+	return dbm.db.update(mark)
+}
+
+// The envelope struct is the parsed form of a stored value
+type envelope struct {
+	version byte
+	suite   Suite
+	flags   byte
+	keyID   KeyID
+	header  []byte
+	body    []byte
+}
+
+// The parseEnvelope function splits the stored value into the header fields and the body, the
+// version is 0 for the legacy values which have no header
+func parseEnvelope(data []byte) (env envelope, err error) {
+	if len(data) <= len(valueMagic) || !bytes.HasPrefix(data, valueMagic) {
+		env.body = data
+		return
+	}
+
+	switch version := data[len(valueMagic)]; version {
+	case formatGCM:
+		env.version = version
+		env.suite = SuiteAESGCM
+		env.body = data[len(valueMagic)+1:]
+	case formatEnvelope:
+		if len(data) < envelopeHeaderSize {
+			return env, errors.New("envelope header too short")
+		}
+		env.version = version
+		env.suite = Suite(data[len(valueMagic)+1])
+		env.flags = data[len(valueMagic)+2]
+		copy(env.keyID[:], data[len(valueMagic)+3:envelopeHeaderSize])
+		env.header = data[:envelopeHeaderSize]
+		env.body = data[envelopeHeaderSize:]
+	default:
+		// an IV of a legacy value which happens to start with the magic bytes
+		env.body = data
+	}
+
+	return
+}
+
+// The bindTo function returns the binding of a value to its bucket and key, which is authenticated
+// with the value
+func bindTo(bucket string, key []byte) []byte {
+	binding := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(bucket)+len(key))
+	binding = append(binding[:binary.PutUvarint(binding, uint64(len(bucket)))], bucket...)
+	return append(binding, key...)
+}
+
+// The sealValue function encrypts the value with the cryptor and prefixes it with the envelope header
+// with the flags, the value is bound to the binding if it is not nil, see bindTo
+func sealValue(c Cryptor, value, binding []byte, flags byte) ([]byte, error) {
+	id := c.KeyID()
+
+	header := make([]byte, envelopeHeaderSize)
+	copy(header, valueMagic)
+	header[len(valueMagic)] = formatEnvelope
+	header[len(valueMagic)+1] = byte(c.Suite())
+	header[len(valueMagic)+2] = flags &^ flagBound
+	copy(header[len(valueMagic)+3:], id[:])
+
+	ad := header
+	if binding != nil {
+		header[len(valueMagic)+2] |= flagBound
+		ad = append(append(make([]byte, 0, len(header)+len(binding)), header...), binding...)
+	}
+
+	body, err := c.Encrypt(value, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, body...), nil
+}
+
+// The openValue function decrypts the stored value by dispatching on its envelope header, the cryptor
+// is picked from the keyring by the suite and the key identifier. The binding must be the same as the one
+// given to the sealValue if the value is bound, ErrTampered is returned otherwise. The flags of the header
+// are returned with the value. Be cautious that the data can be decrypted in place, the same as the
+// Cryptor.Decrypt function
+func openValue(kr *keyring, data, binding []byte) (value []byte, flags byte, err error) {
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//the plain text values are expected while a Rekey encrypts a db which is not encrypted yet
+	if kr.noLegacy && (env.version == formatGCM || env.version == 0 && !kr.plaintext) {
+		return nil, 0, ErrTampered
+	}
+
+	switch env.version {
+	case 0:
+		if kr.plaintext {
+			return env.body, 0, nil
+		}
+		if kr.legacy == nil {
+			return nil, 0, ErrUnknownFormat
+		}
+		value, err = kr.legacy.decryptCFB(env.body)
+		return
+	case formatGCM:
+		if kr.legacy == nil {
+			return nil, 0, ErrUnknownKey
+		}
+		value, err = kr.legacy.Decrypt(env.body, nil)
+		return
+	}
+
+	if env.flags&^knownFlags != 0 {
+		return nil, 0, ErrUnknownFormat
+	}
+
+	c, err := kr.lookup(env.suite, env.keyID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ad := env.header
+	if env.flags&flagBound != 0 {
+		ad = append(append(make([]byte, 0, len(env.header)+len(binding)), env.header...), binding...)
+	}
+
+	value, err = c.Decrypt(env.body, ad)
+	return value, env.flags, err
+}
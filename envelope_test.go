@@ -0,0 +1,255 @@
+package boltsec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"testing"
+)
+
+func TestEnvelopeSealOpen(t *testing.T) {
+	content := []byte(`{"id":"ID-0001","title":"input with more than 16 characters"}`)
+
+	ac, err := newAESCryptor([]byte("secret"))
+	if err != nil {
+		t.Fatalf("newAESCryptor return err: %s", err)
+	}
+
+	enc, err := sealValue(ac, content, nil, 0)
+	if err != nil {
+		t.Fatalf("sealValue return err: %s", err)
+	}
+
+	env, err := parseEnvelope(enc)
+	if err != nil {
+		t.Fatalf("parseEnvelope return err: %s", err)
+	}
+	if env.version != formatEnvelope || env.suite != SuiteAESGCM || env.keyID != ac.id {
+		t.Errorf("parseEnvelope return version: %d, suite: %d, keyID: %x", env.version, env.suite, env.keyID)
+	}
+
+	dec, _, err := openValue(newKeyring(ac), enc, nil)
+	if err != nil {
+		t.Fatalf("openValue return err: %s", err)
+	}
+	if !bytes.Equal(dec, content) {
+		t.Errorf("openValue\n  Expect: %v\n  Actual: %v", content, dec)
+	}
+}
+
+func TestEnvelopeTampered(t *testing.T) {
+	ac, err := newAESCryptor([]byte("secret"))
+	if err != nil {
+		t.Fatalf("newAESCryptor return err: %s", err)
+	}
+
+	enc, err := sealValue(ac, []byte(`{"id":"ID-0001","title":"input with more than 16 characters"}`), bindTo("article", []byte("ID-0001")), 0)
+	if err != nil {
+		t.Fatalf("sealValue return err: %s", err)
+	}
+
+	for i := len(valueMagic) + 1; i < len(enc); i++ {
+		tampered := make([]byte, len(enc))
+		copy(tampered, enc)
+		tampered[i] ^= 0x01
+
+		if _, _, err := openValue(newKeyring(ac), tampered, bindTo("article", []byte("ID-0001"))); err == nil {
+			t.Errorf("openValue with byte %d flipped return no error", i)
+		}
+	}
+
+	other, err := newAESCryptor([]byte("other"))
+	if err != nil {
+		t.Fatalf("newAESCryptor return err: %s", err)
+	}
+	if _, _, err := openValue(newKeyring(other), enc, bindTo("article", []byte("ID-0001"))); err != ErrUnknownKey {
+		t.Errorf("openValue with another secret return err: %v, expect: %v", err, ErrUnknownKey)
+	}
+}
+
+func TestEnvelopeBinding(t *testing.T) {
+	content := []byte(`{"id":"ID-0001","title":"input with more than 16 characters"}`)
+
+	ac, err := newAESCryptor([]byte("secret"))
+	if err != nil {
+		t.Fatalf("newAESCryptor return err: %s", err)
+	}
+
+	enc, err := sealValue(ac, content, bindTo("article", []byte("a-123")), 0)
+	if err != nil {
+		t.Fatalf("sealValue return err: %s", err)
+	}
+
+	bindings := []struct {
+		bucket string
+		key    string
+		err    error
+	}{
+		{"article", "a-123", nil},
+		{"article", "a-456", ErrTampered},
+		{"other", "a-123", ErrTampered},
+		{"articlea", "-123", ErrTampered},
+	}
+
+	for _, iter := range bindings {
+		_, _, err := openValue(newKeyring(ac), append([]byte(nil), enc...), bindTo(iter.bucket, []byte(iter.key)))
+		if err != iter.err {
+			t.Errorf("openValue bound to %s/%s return err: %v, expect: %v", iter.bucket, iter.key, err, iter.err)
+		}
+	}
+}
+
+func TestEnvelopeFormatGCM(t *testing.T) {
+	content := []byte("Long input with more than 16 characters")
+
+	ac, err := newAESCryptor([]byte("Car"))
+	if err != nil {
+		t.Fatalf("newAESCryptor return err: %s", err)
+	}
+
+	// the first authenticated format: magic || version || nonce || ciphertext || tag
+	body, err := ac.Encrypt(content, nil)
+	if err != nil {
+		t.Fatalf("encrypt return err: %s", err)
+	}
+	enc := append(append(append([]byte(nil), valueMagic...), formatGCM), body...)
+
+	dec, _, err := openValue(newKeyring(ac), enc, nil)
+	if err != nil {
+		t.Fatalf("Unable to open version %d value: %v", formatGCM, err)
+	}
+	if !bytes.Equal(dec, content) {
+		t.Errorf("openValue\n  Expect: %v\n  Actual: %v", content, dec)
+	}
+
+	enc[len(enc)-1] ^= 0x01
+	if _, _, err := openValue(newKeyring(ac), enc, nil); err != ErrTampered {
+		t.Errorf("openValue tampered value return err: %v, expect: %v", err, ErrTampered)
+	}
+}
+
+func TestEnvelopeLegacyCFB(t *testing.T) {
+	content := []byte("Long input with more than 16 characters")
+
+	ac, err := newAESCryptor([]byte("Car"))
+	if err != nil {
+		t.Fatalf("newAESCryptor return err: %s", err)
+	}
+
+	// the format written by the earlier versions: IV || AES-CFB ciphertext
+	legacy := make([]byte, aes.BlockSize+len(content))
+	if _, err := io.ReadFull(rand.Reader, legacy[:aes.BlockSize]); err != nil {
+		t.Fatalf("read iv return err: %s", err)
+	}
+	cipher.NewCFBEncrypter(ac.block, legacy[:aes.BlockSize]).XORKeyStream(legacy[aes.BlockSize:], content)
+
+	dec, _, err := openValue(newKeyring(ac), legacy, nil)
+	if err != nil {
+		t.Fatalf("Unable to open legacy value: %v", err)
+	}
+	if !bytes.Equal(dec, content) {
+		t.Errorf("openValue legacy value\n  Expect: %v\n  Actual: %v", content, dec)
+	}
+}
+
+func TestDBMWithoutLegacyFormat(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article"}
+	content := []byte(`{"ID":"ID-0001","Title":"input with more than 16 characters"}`)
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	update := func(fn func(bkt, meta *bolt.Bucket) error) {
+		if err := dbm.openDB(); err != nil {
+			t.Fatalf("openDB return err: %s", err)
+		}
+		defer dbm.closeDB()
+		if err := dbm.db.update(func(tx *boltsecTx) error { return fn(tx.Bucket([]byte("article")), tx.Bucket([]byte(metaBucket))) }); err != nil {
+			t.Fatalf("update return err: %s", err)
+		}
+	}
+
+	//the db created by this version has no legacy values, a value without the envelope is tampered
+	if err = dbm.Save("article", "ID-0003", json.RawMessage(content)); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+	update(func(bkt, meta *bolt.Bucket) error {
+		v := append([]byte(nil), bkt.Get([]byte("ID-0003"))...)
+		v[0] ^= 0x01
+		return bkt.Put([]byte("ID-0003"), v)
+	})
+	if _, err = dbm.Get("article", "ID-0003"); !errors.Is(err, ErrTampered) {
+		t.Errorf("Get value with tampered magic return err: %v, expect: %v", err, ErrTampered)
+	}
+
+	//the db of an earlier version has no marker and the values written directly: IV || AES-CFB ciphertext
+	update(func(bkt, meta *bolt.Bucket) error {
+		if err := bkt.Delete([]byte("ID-0003")); err != nil {
+			return err
+		}
+		return meta.Delete([]byte(noLegacyRecord))
+	})
+	if dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets); err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	keys, _ := dbm.currentKeys()
+	write := func(key string, tamper bool) {
+		legacy := make([]byte, aes.BlockSize+len(content))
+		if _, err := io.ReadFull(rand.Reader, legacy[:aes.BlockSize]); err != nil {
+			t.Fatalf("read iv return err: %s", err)
+		}
+		cipher.NewCFBEncrypter(keys.legacy.block, legacy[:aes.BlockSize]).XORKeyStream(legacy[aes.BlockSize:], content)
+		if tamper {
+			legacy[len(legacy)-2] ^= 0x01
+		}
+		update(func(bkt, meta *bolt.Bucket) error { return bkt.Put([]byte(key), legacy) })
+	}
+	write("ID-0001", false)
+	write("ID-0002", true)
+
+	//the tampered legacy value cannot be detected
+	if res, err := dbm.Get("article", "ID-0002"); err != nil || bytes.Equal(res, content) {
+		t.Errorf("Get tampered legacy value return %s, err: %v", res, err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithoutLegacyFormat())
+	if err != nil {
+		t.Fatalf("NewDBManager without legacy format return err: %s", err)
+	}
+	for _, key := range []string{"ID-0001", "ID-0002"} {
+		if _, err = dbm.Get("article", key); !errors.Is(err, ErrTampered) {
+			t.Errorf("Get legacy value %s return err: %v, expect: %v", key, err, ErrTampered)
+		}
+	}
+
+	//the values upgraded by the Rekey are readable, and the legacy values are rejected without the option
+	if err = dbm.Delete("article", "ID-0002"); err != nil {
+		t.Fatalf("Delete return err: %s", err)
+	}
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if err = dbm.Rekey("secret", "secret", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if res, err := dbm.Get("article", "ID-0001"); err != nil || !bytes.Equal(res, content) {
+		t.Errorf("Get upgraded value return %s, err: %v", res, err)
+	}
+	write("ID-0002", false)
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if _, err = dbm.Get("article", "ID-0002"); !errors.Is(err, ErrTampered) {
+		t.Errorf("Get legacy value after Rekey return err: %v, expect: %v", err, ErrTampered)
+	}
+}
@@ -20,13 +20,12 @@ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
 OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 SOFTWARE.*/
 
-// A sample code to describe how to use the boltsec and use json.Unmarshal to
-// convert the []byte values into Article object
+// A sample code to describe how to use the boltsec and use the boltsec.Store to
+// store the Article objects
 package example
 
 import (
 	"github.com/linkthings/boltsec"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -59,8 +58,7 @@ func (a ArticleSortByUpdateTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ArticleSortByUpdateTime) Less(i, j int) bool { return a[i].UpdatedAt.After(a[j].UpdatedAt) }
 
 type ArticleManager struct {
-	dbm           *boltsec.DBManager
-	bucket        string
+	articles      *boltsec.Store[Article]
 	articlePrefix string
 }
 
@@ -69,8 +67,7 @@ func NewArticleManager(name, fullpath, secret string) (*ArticleManager, error) {
 	var am *ArticleManager
 	if dbm != nil {
 		am = &ArticleManager{
-			dbm:           dbm,
-			bucket:        "al-article",
+			articles:      boltsec.NewStore[Article](dbm, "al-article"),
 			articlePrefix: "a-",
 		}
 	}
@@ -95,25 +92,20 @@ func (am *ArticleManager) NewArticle(name, content, contentToMark string, tags [
 
 func (am *ArticleManager) Seek() (results []*Article, err error) {
 	_func := "Seek"
-	if am == nil || am.dbm == nil {
+	if am == nil || am.articles == nil {
 		return
 	}
 	results = make([]*Article, 0)
 
-	var byteResults [][]byte
+	var articles []Article
 
-	if byteResults, err = am.dbm.GetByPrefix(am.bucket, am.articlePrefix); err != nil {
-		Logger.Printf("%s fsm.dbm.GetByPrefix return err: %s", _func, err)
+	if articles, err = am.articles.List(am.articlePrefix); err != nil {
+		Logger.Printf("%s am.articles.List return err: %s", _func, err)
 		return nil, err
 	}
 
-	for _, iter := range byteResults {
-		resNew := new(Article)
-		if err = json.Unmarshal([]byte(iter), resNew); err != nil {
-			Logger.Printf("%s json.Unmarshal return err: %s", _func, err)
-		} else {
-			results = append(results, resNew)
-		}
+	for i := range articles {
+		results = append(results, &articles[i])
 	}
 
 	sort.Sort(ArticleSortByUpdateTime(results))
@@ -131,15 +123,13 @@ func (am *ArticleManager) GetByID(id string) (result *Article, err error) {
 	key = fmt.Sprintf("%s%s", am.articlePrefix, id)
 	Logger.Printf("%s get using key %s", _func, key)
 
-	var byt []byte
-	byt, err = am.dbm.GetOne(am.bucket, key)
-
-	result = new(Article)
-	if err = json.Unmarshal([]byte(byt), result); err != nil {
-		Logger.Printf("%s json.Unmarshal[%s] return err: %s", _func, id, err)
+	var article Article
+	if article, err = am.articles.Get(key); err != nil {
+		Logger.Printf("%s am.articles.Get[%s] return err: %s", _func, id, err)
+		return nil, err
 	}
 
-	return
+	return &article, nil
 }
 
 func (am *ArticleManager) Save(record *Article) error {
@@ -153,7 +143,7 @@ func (am *ArticleManager) Save(record *Article) error {
 	record.UpdatedAt = time.Now()
 
 	key := fmt.Sprintf("%s%s", am.articlePrefix, record.ID)
-	return am.dbm.Save(am.bucket, key, record)
+	return am.articles.Put(key, *record)
 }
 
 func (am *ArticleManager) Update(record *Article) error {
@@ -179,7 +169,7 @@ func (am *ArticleManager) Delete(id string) error {
 		return boltsec.ErrKeyInvalid
 	}
 	key := fmt.Sprintf("%s%s", am.articlePrefix, id)
-	return am.dbm.Delete(am.bucket, key)
+	return am.articles.Delete(key)
 }
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
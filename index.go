@@ -0,0 +1,277 @@
+package boltsec
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The name of the reserved bucket which keeps the blind indexes, it has a sub-bucket for each indexed bucket
+const indexBucket = "__boltsec_index"
+
+// The name of the metadata record of the key of the blind indexes
+const indexKeyRecord = metaKeyPrefix + "index"
+
+// The sub-buckets of an indexed bucket: the tokens map the token and the stored key of a record to nothing,
+// and the refs map the stored key of a record to its tokens, so that they can be removed when it is changed
+var (
+	indexTokens = []byte("tokens")
+	indexRefs   = []byte("refs")
+)
+
+// The size of a token of the blind indexes
+const indexTokenSize = sha256.Size
+
+// WithIndex declares the fields of the values of the bucket to be indexed, the fields are the top level fields
+// of the JSON of the values, i.e. the names given by the json tags of a struct. The values of the fields are
+// indexed as keyed hashes (blind indexes) in a reserved bucket when the records are saved, thus FindByIndex
+// finds the records by the exact value of a field without decrypting the other records. The key of the
+// hashes is a random key stored encrypted with the secret of the db, or of the bucket.
+//
+// The index doesn't reveal the values, but the records with the same value of a field have the same hash,
+// e.g. how many users share an email domain is hidden while how many users share an email is not. Call
+// RebuildIndex to index the records saved before the option is set.
+func WithIndex(bucket string, fields ...string) Option {
+	return func(dbm *DBManager) {
+		if dbm.indexes == nil {
+			dbm.indexes = make(map[string][]string)
+		}
+		dbm.indexes[bucket] = append(dbm.indexes[bucket], fields...)
+	}
+}
+
+// The FindByIndex function returns the values of the records whose field has the value, the field must be
+// declared by WithIndex. The value is compared with the field by its JSON, e.g. a string, a number or a
+// bool. If the secret is set, the function returns the decrypted content.
+func (dbm *DBManager) FindByIndex(bucket, field string, value interface{}) ([][]byte, error) {
+	var err error
+	var results [][]byte
+	if err = dbm.openDB(); err != nil {
+		return nil, err
+	}
+	defer dbm.closeDB()
+
+	if isReserved(bucket) {
+		return nil, ErrBucketReserved
+	}
+	if !dbm.isIndexed(bucket, field) {
+		return nil, ErrNotIndexed
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	results = make([][]byte, 0)
+	token := indexToken(keys, bucket, field, raw)
+	find := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		tokens, _ := indexBuckets(tx, bucket)
+		if tokens == nil {
+			return nil
+		}
+
+		cursor := tokens.Cursor()
+		for ik, _ := cursor.Seek(token); ik != nil && bytes.HasPrefix(ik, token); ik, _ = cursor.Next() {
+			k := ik[len(token):]
+			v := bkt.Get(k)
+			if v == nil {
+				continue
+			}
+
+			_, dec, err := openRecord(keys, bucket, k, v)
+			if err != nil {
+				return err
+			}
+
+			//the index is not authenticated, thus the value is checked
+			var doc map[string]json.RawMessage
+			if json.Unmarshal(dec, &doc) == nil && bytes.Equal(doc[field], raw) {
+				results = append(results, dec)
+			}
+		}
+		return nil
+	}
+
+	if err = dbm.db.view(find); err != nil {
+		Logger.Printf("FindByIndex return %s", err)
+	}
+
+	return results, err
+}
+
+// RebuildIndex indexes all the records of the bucket with the fields declared by WithIndex, e.g. for the
+// records saved before the fields are declared. The whole bucket is decrypted in one transaction.
+func (dbm *DBManager) RebuildIndex(bucket string) error {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return err
+	}
+	defer dbm.closeDB()
+
+	if isReserved(bucket) {
+		return ErrBucketReserved
+	}
+
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return err
+	}
+
+	rebuild := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		if index := tx.Bucket([]byte(indexBucket)); index != nil && index.Bucket([]byte(bucket)) != nil {
+			if err := index.DeleteBucket([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+
+		//the records are collected first as the cursor must not be used after the db is changed
+		records := make([]record, 0)
+		err := bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			_, value, err := openRecord(keys, bucket, k, v)
+			if err != nil {
+				return err
+			}
+			records = append(records, record{key: append([]byte(nil), k...), value: value})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			if err := dbm.updateIndex(tx, keys, bucket, r.key, r.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return dbm.db.update(rebuild)
+}
+
+// The isIndexed function returns true if the field of the bucket is declared by WithIndex
+func (dbm *DBManager) isIndexed(bucket, field string) bool {
+	for _, name := range dbm.indexes[bucket] {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// The updateIndex function replaces the index entries of the record of the stored key with the ones of
+// the value, the entries are only removed if the value is nil, i.e. the record is deleted
+func (dbm *DBManager) updateIndex(tx *boltsecTx, keys *keyring, bucket string, k, value []byte) error {
+	fields, ok := dbm.indexes[bucket]
+	if !ok {
+		return nil
+	}
+
+	index, err := tx.CreateBucketIfNotExists([]byte(indexBucket))
+	if err != nil {
+		return err
+	}
+	sub, err := index.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	tokens, err := sub.CreateBucketIfNotExists(indexTokens)
+	if err != nil {
+		return err
+	}
+	refs, err := sub.CreateBucketIfNotExists(indexRefs)
+	if err != nil {
+		return err
+	}
+
+	old := refs.Get(k)
+	for ; len(old) >= indexTokenSize; old = old[indexTokenSize:] {
+		if err := tokens.Delete(append(append([]byte(nil), old[:indexTokenSize]...), k...)); err != nil {
+			return err
+		}
+	}
+	if err := refs.Delete(k); err != nil {
+		return err
+	}
+
+	//only the JSON objects are indexed
+	var doc map[string]json.RawMessage
+	if value == nil || json.Unmarshal(value, &doc) != nil {
+		return nil
+	}
+
+	ref := make([]byte, 0)
+	for _, field := range fields {
+		raw, ok := doc[field]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+
+		token := indexToken(keys, bucket, field, raw)
+		if err := tokens.Put(append(append([]byte(nil), token...), k...), []byte{}); err != nil {
+			return err
+		}
+		ref = append(ref, token...)
+	}
+
+	if len(ref) == 0 {
+		return nil
+	}
+	return refs.Put(k, ref)
+}
+
+// The indexBuckets function returns the tokens and the refs sub-buckets of the index of the bucket, they are
+// nil if the bucket has no index yet
+func indexBuckets(tx *boltsecTx, bucket string) (tokens, refs *bolt.Bucket) {
+	index := tx.Bucket([]byte(indexBucket))
+	if index == nil {
+		return nil, nil
+	}
+	sub := index.Bucket([]byte(bucket))
+	if sub == nil {
+		return nil, nil
+	}
+	return sub.Bucket(indexTokens), sub.Bucket(indexRefs)
+}
+
+// The indexToken function returns the keyed hash of the JSON value of the field, the bucket and the field
+// are hashed with their length so that the token of a field never equals the one of another field. The
+// key is nil if the values of the bucket are not encrypted
+func indexToken(keys *keyring, bucket, field string, value []byte) []byte {
+	var key []byte
+	if keys != nil {
+		key = keys.indexKey
+	}
+
+	mac := hmac.New(sha256.New, key)
+	size := make([]byte, binary.MaxVarintLen64)
+	mac.Write(size[:binary.PutUvarint(size, uint64(len(bucket)))])
+	mac.Write([]byte(bucket))
+	mac.Write(size[:binary.PutUvarint(size, uint64(len(field)))])
+	mac.Write([]byte(field))
+	mac.Write(value)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package boltsec
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type indexedUser struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Age   int    `json:"age"`
+}
+
+func TestDBMIndex(t *testing.T) {
+	for _, secret := range []string{"", "secret"} {
+		var err error
+		dir := t.TempDir()
+		buckets := []string{"user"}
+
+		dbm, err := NewDBManager("test.dat", dir, secret, false, buckets)
+		if err != nil {
+			t.Fatalf("NewDBManager return err: %s", err)
+		}
+
+		//saved before the index is declared
+		if err = dbm.Save("user", "u1", indexedUser{"u1", "a@example.com", 30}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+
+		opts := []Option{WithIndex("user", "email", "age")}
+		if secret != "" {
+			opts = append(opts, WithKeyEncryption())
+		}
+		dbm, err = NewDBManager("test.dat", dir, secret, false, buckets, opts...)
+		if err != nil {
+			t.Fatalf("NewDBManager return err: %s", err)
+		}
+
+		users := []indexedUser{{"u2", "b@example.com", 30}, {"u3", "c@example.com", 40}}
+		for _, u := range users {
+			if err = dbm.Save("user", u.ID, u); err != nil {
+				t.Fatalf("Save return err: %s", err)
+			}
+		}
+
+		find := func(field string, value interface{}) (ids []string) {
+			results, err := dbm.FindByIndex("user", field, value)
+			if err != nil {
+				t.Fatalf("FindByIndex return err: %s", err)
+			}
+			for _, res := range results {
+				var u indexedUser
+				json.Unmarshal(res, &u)
+				ids = append(ids, u.ID)
+			}
+			return
+		}
+
+		if ids := find("email", "b@example.com"); len(ids) != 1 || ids[0] != "u2" {
+			t.Errorf("FindByIndex email return %v, expect: [u2]", ids)
+		}
+		if ids := find("age", 30); len(ids) != 1 {
+			t.Errorf("FindByIndex age before RebuildIndex return %v, expect: [u2]", ids)
+		}
+
+		if err = dbm.RebuildIndex("user"); err != nil {
+			t.Fatalf("RebuildIndex return err: %s", err)
+		}
+		if ids := find("age", 30); len(ids) != 2 {
+			t.Errorf("FindByIndex age return %v, expect: [u1 u2]", ids)
+		}
+
+		//the old entries are removed when the record is changed or deleted
+		if err = dbm.Save("user", "u2", indexedUser{"u2", "new@example.com", 30}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+		if ids := find("email", "b@example.com"); len(ids) != 0 {
+			t.Errorf("FindByIndex old email return %v", ids)
+		}
+		if ids := find("email", "new@example.com"); len(ids) != 1 {
+			t.Errorf("FindByIndex new email return %v, expect: [u2]", ids)
+		}
+		if err = dbm.Delete("user", "u3"); err != nil {
+			t.Fatalf("Delete return err: %s", err)
+		}
+		if ids := find("age", 40); len(ids) != 0 {
+			t.Errorf("FindByIndex deleted record return %v", ids)
+		}
+
+		if _, err = dbm.FindByIndex("user", "id", "u1"); err != ErrNotIndexed {
+			t.Errorf("FindByIndex of a field not indexed return err: %v, expect: %v", err, ErrNotIndexed)
+		}
+	}
+
+	if _, err := NewDBManager("test.dat", t.TempDir(), "", false, []string{indexBucket}); err != ErrBucketReserved {
+		t.Errorf("NewDBManager with index bucket return err: %v, expect: %v", err, ErrBucketReserved)
+	}
+}
+
+func TestDBMIndexRekey(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"user"}
+	opts := []Option{WithIndex("user", "email")}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if err = dbm.Save("user", "u1", indexedUser{"u1", "a@example.com", 30}); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//the key of the index is kept by the Rekey, thus the index stays valid
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	dbm, err = NewDBManager("test.dat", dir, "new", false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if results, err := dbm.FindByIndex("user", "email", "a@example.com"); err != nil || len(results) != 1 {
+		t.Errorf("FindByIndex after Rekey return %d results, err: %v", len(results), err)
+	}
+}
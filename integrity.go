@@ -0,0 +1,421 @@
+package boltsec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"strings"
+)
+
+// The name of the reserved bucket which keeps the leaves of the digests, it has a sub-bucket for each bucket
+// with the leaf of every record
+const digestBucket = "__boltsec_digest"
+
+// The name of the metadata record of the key of the digests
+const integrityKeyRecord = metaKeyPrefix + "integrity"
+
+// The prefix of the metadata records which keep the digest of each bucket
+const digestPrefix = "digest/"
+
+// The size of the digest record of a bucket: the XOR of the leaves, the number of the leaves, the version
+// which is increased by each change, and the keyed hash of them
+const digestRecordSize = sha256.Size + 8 + 8 + sha256.Size
+
+// The bucketDigest struct is the parsed digest record of a bucket, it is not valid if its keyed hash
+// doesn't match, e.g. the sum is changed in the file to hide a deleted record
+type bucketDigest struct {
+	sum     []byte
+	count   uint64
+	version uint64
+	valid   bool
+}
+
+// The IssueKind type tells what is wrong with a record found by Verify
+type IssueKind int
+
+// The kinds of the issues reported by Verify
+const (
+	// IssueModified is a record which is changed, swapped or rolled back to an older version
+	IssueModified IssueKind = iota + 1
+	// IssueMissing is a record which is deleted without Delete
+	IssueMissing
+	// IssueUnexpected is a record which is added without Save
+	IssueUnexpected
+	// IssueUndecryptable is a record which cannot be decrypted, e.g. ErrTampered
+	IssueUndecryptable
+	// IssueDigest is a bucket whose digest doesn't match its records, e.g. some records are rolled back
+	// together with their leaves
+	IssueDigest
+)
+
+// The Issue struct is an inconsistent record or bucket found by Verify
+type Issue struct {
+	Kind   IssueKind
+	Bucket string
+	// Key is the key of the record, it is the stored key if the keys of the bucket are encrypted and the
+	// record is missing or cannot be decrypted, and "" for the IssueDigest
+	Key string
+	// Err is the error of the IssueUndecryptable
+	Err error
+}
+
+// The VerifyReport struct is returned by Verify
+type VerifyReport struct {
+	// Buckets is the number of the buckets verified
+	Buckets int
+	// Records is the number of the records verified
+	Records int
+	Issues  []Issue
+}
+
+// WithIntegrity maintains an authenticated digest of the buckets, all the buckets if no bucket is given.
+// Every record has a leaf, which is a keyed hash of its bucket, key and stored value, and the digest of a
+// bucket is the XOR of its leaves and their number, authenticated by a keyed hash; both are updated by Save
+// and Delete. Verify walks the db and reports the records which are changed, deleted, added or rolled back
+// to an older version outside the DBManager, even if their leaves are removed or rolled back with them.
+//
+// The key of the hashes is a random key stored encrypted with the secret of the db, or of the bucket; a
+// db without a secret only detects the accidental changes. Rolling back the whole db file cannot be detected
+// by the db itself, keep the Digest of the db elsewhere to detect it. The blobs of PutReader are not covered,
+// and the records saved before the option is set are reported until RebuildDigest is called.
+func WithIntegrity(buckets ...string) Option {
+	return func(dbm *DBManager) {
+		dbm.integrity = true
+		dbm.integrityBuckets = buckets
+	}
+}
+
+// The hasIntegrity function returns true if the digest of the bucket is maintained
+func (dbm *DBManager) hasIntegrity(bucket string) bool {
+	if !dbm.integrity {
+		return false
+	}
+	if len(dbm.integrityBuckets) == 0 {
+		return true
+	}
+	for _, name := range dbm.integrityBuckets {
+		if name == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks every record of the buckets with the integrity enabled by WithIntegrity against its leaf and
+// the digest of the bucket, and returns all the issues found. The values are also decrypted to check that
+// they are readable. Each bucket is verified in one transaction.
+func (dbm *DBManager) Verify() (report VerifyReport, err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	var names []string
+	list := func(tx *boltsecTx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !isReserved(string(name)) && dbm.hasIntegrity(string(name)) {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	}
+	if err = dbm.db.view(list); err != nil {
+		return
+	}
+
+	for _, bucket := range names {
+		keys, err := dbm.keysFor(bucket)
+		if err != nil {
+			return report, err
+		}
+
+		verify := func(tx *boltsecTx) error {
+			issues, records := verifyBucket(tx, keys, bucket)
+			report.Buckets++
+			report.Records += records
+			report.Issues = append(report.Issues, issues...)
+			return nil
+		}
+		if err = dbm.db.view(verify); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// The verifyBucket function returns the issues of the bucket and the number of its records
+func verifyBucket(tx *boltsecTx, keys *keyring, bucket string) (issues []Issue, records int) {
+	bkt := tx.Bucket([]byte(bucket))
+	leaves := digestLeaves(tx, bucket)
+
+	name := func(k, v []byte) string {
+		if v != nil {
+			if key, _, err := openRecord(keys, bucket, k, v); err == nil {
+				return string(key)
+			}
+		}
+		return string(k)
+	}
+
+	sum := make([]byte, sha256.Size)
+	bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		records++
+
+		if _, _, err := openRecord(keys, bucket, k, v); err != nil && !errors.Is(err, ErrShredded) {
+			issues = append(issues, Issue{Kind: IssueUndecryptable, Bucket: bucket, Key: string(k), Err: err})
+		}
+
+		var stored []byte
+		if leaves != nil {
+			stored = leaves.Get(k)
+		}
+		switch {
+		case stored == nil:
+			issues = append(issues, Issue{Kind: IssueUnexpected, Bucket: bucket, Key: name(k, v)})
+		case !hmac.Equal(stored, digestLeaf(keys, bucket, k, v)):
+			issues = append(issues, Issue{Kind: IssueModified, Bucket: bucket, Key: name(k, v)})
+		}
+		return nil
+	})
+
+	var count uint64
+	if leaves != nil {
+		leaves.ForEach(func(k, leaf []byte) error {
+			if bkt.Get(k) == nil {
+				issues = append(issues, Issue{Kind: IssueMissing, Bucket: bucket, Key: string(k)})
+			}
+			xorInto(sum, leaf)
+			count++
+			return nil
+		})
+	}
+
+	//the leaves removed or rolled back with their records change the sum or the number of the leaves
+	d := loadDigest(tx, keys, bucket)
+	if !d.valid || !hmac.Equal(sum, d.sum) || count != d.count {
+		issues = append(issues, Issue{Kind: IssueDigest, Bucket: bucket})
+	}
+	return issues, records
+}
+
+// Digest returns the digest of the db, which is a keyed hash of the digests of all the buckets maintained
+// by WithIntegrity. It changes whenever a record is saved or deleted, thus it can be kept outside the db,
+// e.g. in a backup log, to detect the rollback of the whole db file.
+func (dbm *DBManager) Digest() ([]byte, error) {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return nil, err
+	}
+	defer dbm.closeDB()
+
+	keys, err := dbm.currentKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, integrityKey(keys))
+	digest := func(tx *boltsecTx) error {
+		cursor := tx.Bucket([]byte(metaBucket)).Cursor()
+		for k, v := cursor.Seek([]byte(digestPrefix)); k != nil && strings.HasPrefix(string(k), digestPrefix); k, v = cursor.Next() {
+			mac.Write(bindTo(strings.TrimPrefix(string(k), digestPrefix), v))
+		}
+		return nil
+	}
+
+	if err = dbm.db.view(digest); err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
+
+// RebuildDigest computes the leaves and the digest of the bucket from its records, e.g. for the records
+// saved before WithIntegrity is set. The records which are changed outside the DBManager are accepted,
+// thus call Verify first.
+func (dbm *DBManager) RebuildDigest(bucket string) error {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return err
+	}
+	defer dbm.closeDB()
+
+	if isReserved(bucket) {
+		return ErrBucketReserved
+	}
+
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return err
+	}
+
+	rebuild := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		digests, err := tx.CreateBucketIfNotExists([]byte(digestBucket))
+		if err != nil {
+			return err
+		}
+		if digests.Bucket([]byte(bucket)) != nil {
+			if err = digests.DeleteBucket([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		leaves, err := digests.CreateBucket([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		sum := make([]byte, sha256.Size)
+		var count uint64
+		err = bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			leaf := digestLeaf(keys, bucket, k, v)
+			xorInto(sum, leaf)
+			count++
+			return leaves.Put(k, leaf)
+		})
+		if err != nil {
+			return err
+		}
+
+		d := loadDigest(tx, keys, bucket)
+		d.sum, d.count, d.version, d.valid = sum, count, d.version+1, true
+		return tx.Bucket([]byte(metaBucket)).Put([]byte(digestPrefix+bucket), d.seal(keys, bucket))
+	}
+
+	return dbm.db.update(rebuild)
+}
+
+// The updateDigest function replaces the leaf of the stored key with the one of the stored value, and updates
+// the digest of the bucket. The leaf is only removed if the value is nil, i.e. the record is deleted. A digest
+// which is not valid stays invalid, so that a change cannot authenticate the sum changed in the file
+func (dbm *DBManager) updateDigest(tx *boltsecTx, keys *keyring, bucket string, k, v []byte) error {
+	if !dbm.hasIntegrity(bucket) {
+		return nil
+	}
+
+	digests, err := tx.CreateBucketIfNotExists([]byte(digestBucket))
+	if err != nil {
+		return err
+	}
+	leaves, err := digests.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+
+	d := loadDigest(tx, keys, bucket)
+	if old := leaves.Get(k); old != nil {
+		xorInto(d.sum, old)
+		d.count--
+	}
+
+	if v == nil {
+		err = leaves.Delete(k)
+	} else {
+		leaf := digestLeaf(keys, bucket, k, v)
+		xorInto(d.sum, leaf)
+		d.count++
+		err = leaves.Put(k, leaf)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.version++
+	return tx.Bucket([]byte(metaBucket)).Put([]byte(digestPrefix+bucket), d.seal(keys, bucket))
+}
+
+// The digestLeaves function returns the sub-bucket of the leaves of the bucket, nil if it has none
+func digestLeaves(tx *boltsecTx, bucket string) *bolt.Bucket {
+	digests := tx.Bucket([]byte(digestBucket))
+	if digests == nil {
+		return nil
+	}
+	return digests.Bucket([]byte(bucket))
+}
+
+// The loadDigest function returns a copy of the digest of the bucket, which is the empty digest if it has
+// none. The digest is not valid if its record has another size or its keyed hash doesn't match
+func loadDigest(tx *boltsecTx, keys *keyring, bucket string) (d bucketDigest) {
+	d.sum = make([]byte, sha256.Size)
+	v := tx.Bucket([]byte(metaBucket)).Get([]byte(digestPrefix + bucket))
+	if v == nil {
+		d.valid = true
+		return
+	}
+	if len(v) != digestRecordSize {
+		return
+	}
+
+	copy(d.sum, v)
+	d.count = binary.BigEndian.Uint64(v[sha256.Size:])
+	d.version = binary.BigEndian.Uint64(v[sha256.Size+8:])
+	d.valid = hmac.Equal(v[sha256.Size+16:], d.mac(keys, bucket))
+	return
+}
+
+// The seal function returns the digest record of the bucket, the keyed hash of a digest which is not valid
+// is zeros
+func (d *bucketDigest) seal(keys *keyring, bucket string) []byte {
+	output := make([]byte, digestRecordSize)
+	copy(output, d.sum)
+	binary.BigEndian.PutUint64(output[sha256.Size:], d.count)
+	binary.BigEndian.PutUint64(output[sha256.Size+8:], d.version)
+	if d.valid {
+		copy(output[sha256.Size+16:], d.mac(keys, bucket))
+	}
+	return output
+}
+
+// The mac function returns the keyed hash of the bucket, the sum, the number of the leaves and the version
+func (d *bucketDigest) mac(keys *keyring, bucket string) []byte {
+	state := make([]byte, 16)
+	binary.BigEndian.PutUint64(state, d.count)
+	binary.BigEndian.PutUint64(state[8:], d.version)
+
+	mac := hmac.New(sha256.New, integrityKey(keys))
+	mac.Write(bindTo(bucket, []byte(digestPrefix)))
+	mac.Write(d.sum)
+	mac.Write(state)
+	return mac.Sum(nil)
+}
+
+// The digestLeaf function returns the leaf of the record, the keyed hash of its bucket, key and stored value
+func digestLeaf(keys *keyring, bucket string, k, v []byte) []byte {
+	value := sha256.Sum256(v)
+	mac := hmac.New(sha256.New, integrityKey(keys))
+	mac.Write(bindTo(bucket, k))
+	mac.Write(value[:])
+	return mac.Sum(nil)
+}
+
+// The integrityKey function returns the key of the digests, which is nil if the values are not encrypted
+func integrityKey(keys *keyring) []byte {
+	if keys == nil {
+		return nil
+	}
+	return keys.integrityKey
+}
+
+// The xorInto function XORs the leaf into the sum
+func xorInto(sum, leaf []byte) {
+	for i := range sum {
+		sum[i] ^= leaf[i]
+	}
+}
+
+// The isReserved function returns true if the bucket is reserved by the package
+func isReserved(bucket string) bool {
+	return bucket == metaBucket || bucket == indexBucket || bucket == digestBucket
+}
@@ -0,0 +1,174 @@
+package boltsec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBMVerify(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "plain"}
+	opts := []Option{WithIntegrity("article")}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", true, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	for _, id := range []string{"ID-0001", "ID-0002", "ID-0003", "ID-0004", "ID-0005"} {
+		if err = dbm.Save("article", id, Article{ID: id, Title: "version 1"}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+		if err = dbm.Save("plain", id, Article{ID: id, Title: "not verified"}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	digest, err := dbm.Digest()
+	if err != nil {
+		t.Fatalf("Digest return err: %s", err)
+	}
+	report, err := dbm.Verify()
+	if err != nil || len(report.Issues) != 0 {
+		t.Fatalf("Verify return %+v, err: %v", report, err)
+	}
+
+	//keep the version 1 of a record and its leaf to roll them back
+	var oldValue, oldLeaf []byte
+	dbm.db.view(func(tx *boltsecTx) error {
+		oldValue = append([]byte(nil), tx.Bucket([]byte("article")).Get([]byte("ID-0005"))...)
+		oldLeaf = append([]byte(nil), digestLeaves(tx, "article").Get([]byte("ID-0005"))...)
+		return nil
+	})
+
+	if err = dbm.Save("article", "ID-0005", Article{ID: "ID-0005", Title: "version 2"}); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+	if err = dbm.Delete("article", "ID-0004"); err != nil {
+		t.Fatalf("Delete return err: %s", err)
+	}
+	if res, err := dbm.Digest(); err != nil || bytes.Equal(res, digest) {
+		t.Errorf("Digest after Save return %x, err: %v", res, err)
+	}
+
+	report, err = dbm.Verify()
+	if err != nil || len(report.Issues) != 0 || report.Buckets != 1 || report.Records != 4 {
+		t.Fatalf("Verify return %+v, err: %v", report, err)
+	}
+	dbm.SetBatchMode(false)
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("article"))
+		bkt.Put([]byte("ID-0001"), append([]byte(nil), bkt.Get([]byte("ID-0002"))...))
+		bkt.Delete([]byte("ID-0003"))
+		bkt.Put([]byte("ID-0009"), []byte("added"))
+		//rolled back together with its leaf
+		bkt.Put([]byte("ID-0005"), oldValue)
+		tx.Bucket([]byte(digestBucket)).Bucket([]byte("article")).Put([]byte("ID-0005"), oldLeaf)
+		return nil
+	})
+	db.Close()
+
+	dbm, err = NewDBManager("test.dat", dir, "new", false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if report, err = dbm.Verify(); err != nil {
+		t.Fatalf("Verify return err: %s", err)
+	}
+
+	//the swapped value is bound to another key, and the added one is not encrypted
+	type found struct {
+		kind IssueKind
+		key  string
+	}
+	expected := map[found]bool{
+		{IssueModified, "ID-0001"}:      true,
+		{IssueUndecryptable, "ID-0001"}: true,
+		{IssueMissing, "ID-0003"}:       true,
+		{IssueUnexpected, "ID-0009"}:    true,
+		{IssueUndecryptable, "ID-0009"}: true,
+		{IssueDigest, ""}:               true,
+	}
+	for _, issue := range report.Issues {
+		if !expected[found{issue.Kind, issue.Key}] || issue.Bucket != "article" {
+			t.Errorf("Verify return unexpected issue %+v", issue)
+		}
+		delete(expected, found{issue.Kind, issue.Key})
+	}
+	if len(expected) != 0 {
+		t.Errorf("Verify doesn't report %v", expected)
+	}
+
+	if err = dbm.RebuildDigest("article"); err != nil {
+		t.Fatalf("RebuildDigest return err: %s", err)
+	}
+	if report, err = dbm.Verify(); err != nil || len(report.Issues) != 2 || report.Issues[0].Kind != IssueUndecryptable {
+		t.Errorf("Verify after RebuildDigest return %+v, err: %v", report, err)
+	}
+}
+
+func TestDBMVerifyHiddenDelete(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{"article"}, WithIntegrity())
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	for _, id := range []string{"ID-0001", "ID-0002", "ID-0003"} {
+		if err = dbm.Save("article", id, Article{ID: id, Title: "version 1"}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+
+	//the record is removed with its leaf, and the leaf is removed from the sum and the number of the leaves
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		leaves := tx.Bucket([]byte(digestBucket)).Bucket([]byte("article"))
+		meta := tx.Bucket([]byte(metaBucket))
+		digest := append([]byte(nil), meta.Get([]byte(digestPrefix+"article"))...)
+		xorInto(digest[:sha256.Size], leaves.Get([]byte("ID-0002")))
+		binary.BigEndian.PutUint64(digest[sha256.Size:], binary.BigEndian.Uint64(digest[sha256.Size:])-1)
+
+		tx.Bucket([]byte("article")).Delete([]byte("ID-0002"))
+		leaves.Delete([]byte("ID-0002"))
+		return meta.Put([]byte(digestPrefix+"article"), digest)
+	})
+	db.Close()
+
+	report, err := dbm.Verify()
+	if err != nil || len(report.Issues) != 1 || report.Issues[0].Kind != IssueDigest {
+		t.Errorf("Verify of hidden delete return %+v, err: %v", report, err)
+	}
+
+	//the next change doesn't authenticate the changed digest
+	if err = dbm.Save("article", "ID-0004", Article{ID: "ID-0004", Title: "version 1"}); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+	if report, err = dbm.Verify(); err != nil || len(report.Issues) != 1 || report.Issues[0].Kind != IssueDigest {
+		t.Errorf("Verify after Save return %+v, err: %v", report, err)
+	}
+
+	if err = dbm.RebuildDigest("article"); err != nil {
+		t.Fatalf("RebuildDigest return err: %s", err)
+	}
+	if report, err = dbm.Verify(); err != nil || len(report.Issues) != 0 || report.Records != 3 {
+		t.Errorf("Verify after RebuildDigest return %+v, err: %v", report, err)
+	}
+}
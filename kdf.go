@@ -0,0 +1,136 @@
+package boltsec
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"io"
+)
+
+// The name of the metadata record which keeps the KDF settings of the db
+const kdfRecord = "kdf"
+
+const (
+	kdfArgon2id = "argon2id"
+	kdfSaltSize = 16
+)
+
+// The KDFParams struct keeps the tunable Argon2id parameters used to derive the key from the secret
+type KDFParams struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is the size of the memory in KiB
+	Memory uint32
+	// Threads is the number of threads used for the derivation
+	Threads uint8
+}
+
+// DefaultKDFParams is the recommended Argon2id parameters of RFC 9106 for memory constrained environments
+var DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// MaxKDFTime and MaxKDFMemory are the largest Time and Memory accepted by WithKDF and from the KDF settings
+// stored in the db, so that tampered settings cannot make the derivation run or allocate without bound
+var (
+	MaxKDFTime   uint32 = 64
+	MaxKDFMemory uint32 = 4 * 1024 * 1024
+)
+
+// The validate function returns ErrKDFInvalid if the params cannot be used by Argon2id or exceed the
+// MaxKDFTime or MaxKDFMemory; Argon2id needs at least 8 KiB of memory per thread
+func (p KDFParams) validate() error {
+	if p.Time == 0 || p.Time > MaxKDFTime || p.Threads == 0 {
+		return ErrKDFInvalid
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > MaxKDFMemory {
+		return ErrKDFInvalid
+	}
+	return nil
+}
+
+// The kdfSettings struct is stored in the metadata bucket, the same salt and parameters must be used
+// to derive the key every time the db is opened
+type kdfSettings struct {
+	Algorithm string `json:"alg"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+// WithKDF enables the Argon2id key derivation with a random salt for a new db, the salt and the params
+// are stored in the metadata bucket and used automatically whenever the db is opened afterwards, even
+// without this option. For a db encrypted before the KDF is enabled, the existing values stay readable
+// and can be re-encrypted with the derived key by calling Rekey(secret, secret). NewDBManager returns
+// ErrKDFInvalid if the params are not valid, see MaxKDFTime and MaxKDFMemory.
+func WithKDF(params KDFParams) Option {
+	return func(dbm *DBManager) {
+		dbm.kdfParams = &params
+	}
+}
+
+// The deriveKey function derives the AES key from the secret
+func (s *kdfSettings) deriveKey(secret []byte) ([]byte, error) {
+	if s.Algorithm != kdfArgon2id {
+		return nil, errors.New("unknown kdf algorithm " + s.Algorithm)
+	}
+	if len(s.Salt) < kdfSaltSize {
+		return nil, ErrKDFInvalid
+	}
+	if err := (KDFParams{Time: s.Time, Memory: s.Memory, Threads: s.Threads}).validate(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey(secret, s.Salt, s.Time, s.Memory, s.Threads, 32), nil
+}
+
+// The loadKDF function returns the KDF settings stored in the metadata bucket, the settings are created
+// if they don't exist and the KDF is enabled by WithKDF. Nil is returned if the KDF is not used by the db
+func (dbm *DBManager) loadKDF() (settings *kdfSettings, err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	load := func(tx *boltsecTx) error {
+		v := tx.Bucket([]byte(metaBucket)).Get([]byte(kdfRecord))
+		if v == nil {
+			return nil
+		}
+		settings = new(kdfSettings)
+		return json.Unmarshal(v, settings)
+	}
+
+	if err = dbm.db.view(load); err != nil || settings != nil || dbm.kdfParams == nil {
+		return
+	}
+
+	create := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+
+		//another DBManager may have created the settings in the meantime
+		if v := bkt.Get([]byte(kdfRecord)); v != nil {
+			settings = new(kdfSettings)
+			return json.Unmarshal(v, settings)
+		}
+
+		settings = &kdfSettings{
+			Algorithm: kdfArgon2id,
+			Salt:      make([]byte, kdfSaltSize),
+			Time:      dbm.kdfParams.Time,
+			Memory:    dbm.kdfParams.Memory,
+			Threads:   dbm.kdfParams.Threads,
+		}
+		if _, err := io.ReadFull(rand.Reader, settings.Salt); err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(kdfRecord), value)
+	}
+
+	err = dbm.db.update(create)
+	return
+}
@@ -0,0 +1,196 @@
+package boltsec
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var testKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+func TestDBMKDF(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName}, WithKDF(testKDFParams))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	settings, err := dbm.loadKDF()
+	if err != nil || settings == nil {
+		t.Fatalf("loadKDF return settings: %v, err: %v", settings, err)
+	}
+	if settings.Algorithm != kdfArgon2id || len(settings.Salt) != kdfSaltSize || settings.Memory != testKDFParams.Memory {
+		t.Errorf("loadKDF return settings: %+v", settings)
+	}
+
+	legacy, _ := newAESCryptor([]byte("secret"))
+	if keys, _ := dbm.currentKeys(); keys.primary.KeyID() == legacy.id {
+		t.Errorf("the primary key is not derived by the KDF")
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	//the KDF settings are used without the option once they are stored in the db
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	var bytes []byte
+	if bytes, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Fatalf("GetOne return err: %s", err)
+	}
+
+	resNew := new(Article)
+	if err = json.Unmarshal(bytes, resNew); err != nil {
+		t.Errorf("json.Unmarshal return err: %s", err)
+	}
+	if resNew.Title != data.Title {
+		t.Errorf("returned Title is not equal: new:%s, org: %s", resNew.Title, data.Title)
+	}
+}
+
+func TestDBMKDFMigrate(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, []string{bucketName}, WithKDF(testKDFParams))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	if _, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Errorf("GetOne value saved before the KDF return err: %s", err)
+	}
+
+	var last RekeyProgress
+	if err = dbm.Rekey("secret", "secret", func(p RekeyProgress) { last = p }); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if last.Rekeyed != 1 {
+		t.Errorf("Rekey progress: %+v", last)
+	}
+
+	if _, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Errorf("GetOne after Rekey return err: %s", err)
+	}
+}
+
+func TestDBMKDFInvalid(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article"}
+
+	invalid := []KDFParams{
+		{},
+		{Time: 1, Memory: 8 * 1024},
+		{Time: 1, Memory: 4, Threads: 1},
+		{Time: MaxKDFTime + 1, Memory: 8 * 1024, Threads: 1},
+		{Time: 1, Memory: MaxKDFMemory + 1, Threads: 1},
+	}
+	for _, params := range invalid {
+		if _, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithKDF(params)); err != ErrKDFInvalid {
+			t.Errorf("NewDBManager with KDF params %+v return err: %v, expect: %v", params, err, ErrKDFInvalid)
+		}
+	}
+
+	//the tampered settings stored in the db are rejected instead of being given to Argon2id
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets, WithKDF(testKDFParams))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	tamper := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		settings := new(kdfSettings)
+		if err := json.Unmarshal(bkt.Get([]byte(kdfRecord)), settings); err != nil {
+			return err
+		}
+		settings.Time = 0
+		value, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(kdfRecord), value)
+	}
+	if err = dbm.openDB(); err != nil {
+		t.Fatalf("openDB return err: %s", err)
+	}
+	err = dbm.db.update(tamper)
+	dbm.closeDB()
+	if err != nil {
+		t.Fatalf("tamper KDF settings return err: %s", err)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "secret", false, buckets); err != ErrKDFInvalid {
+		t.Errorf("NewDBManager with tampered KDF settings return err: %v, expect: %v", err, ErrKDFInvalid)
+	}
+}
+
+func TestDBMReservedBucket(t *testing.T) {
+	if _, err := NewDBManager("test.dat", t.TempDir(), "", false, []string{metaBucket}); err != ErrBucketReserved {
+		t.Errorf("NewDBManager return err: %v, expect: %v", err, ErrBucketReserved)
+	}
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"article"}, WithIntegrity())
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	for _, bucket := range []string{metaBucket, indexBucket, digestBucket} {
+		calls := map[string]func() error{
+			"GetByPrefix": func() error {
+				_, err := dbm.GetByPrefix(bucket, "")
+				return err
+			},
+			"GetKeyList": func() error {
+				_, err := dbm.GetKeyList(bucket, "")
+				return err
+			},
+			"GetFirstByPrefix": func() error {
+				_, err := dbm.GetFirstByPrefix(bucket, canaryRecord)
+				return err
+			},
+			"Get": func() error {
+				_, err := dbm.Get(bucket, canaryRecord)
+				return err
+			},
+			"FindByIndex": func() error {
+				_, err := dbm.FindByIndex(bucket, "id", "a-1")
+				return err
+			},
+			"Store.List": func() error {
+				_, err := NewStore[Article](dbm, bucket).List("")
+				return err
+			},
+			"RebuildIndex":  func() error { return dbm.RebuildIndex(bucket) },
+			"RebuildDigest": func() error { return dbm.RebuildDigest(bucket) },
+			"Save":          func() error { return dbm.Save(bucket, canaryRecord, Article{ID: "a-1"}) },
+			"Delete":        func() error { return dbm.Delete(bucket, canaryRecord) },
+		}
+		for name, call := range calls {
+			if err = call(); err != ErrBucketReserved {
+				t.Errorf("%s of %s return err: %v, expect: %v", name, bucket, err, ErrBucketReserved)
+			}
+		}
+	}
+
+	if _, err = NewDBManager("test.dat", dbm.path, "secret", false, []string{"article"}); err != nil {
+		t.Errorf("NewDBManager after the calls return err: %v", err)
+	}
+}
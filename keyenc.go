@@ -0,0 +1,149 @@
+package boltsec
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"strings"
+)
+
+// The prefix of the metadata records which keep the random keys of the db, such as the key to hash
+// the keys of the records. Those keys are encrypted with the secret of the db and re-encrypted by the Rekey,
+// while they stay the same so that the data derived from them stay valid
+const metaKeyPrefix = "key/"
+
+// The name of the metadata record of the key to hash the keys of the records
+const keyHashRecord = metaKeyPrefix + "keyhash"
+
+// WithKeyEncryption stores the keys of the records in the buckets as keyed hashes instead of plain text,
+// all the buckets if no bucket is given. The key is embedded in the encrypted value, thus GetOne, Delete
+// and GetKeyList keep working with the real keys. It requires the secret (or a cryptor or a key provider)
+// and should be enabled for a new bucket, the existing keys of a bucket are not converted. NewDBManager
+// returns ErrSecretInvalid if the db, or one of the given buckets, is not encrypted.
+//
+// The trade-off is that the hashed keys have no order, thus the prefix scans, i.e. GetByPrefix, GetKeyList
+// and the GetOne of a key which does not exist, have to decrypt every value of the bucket and sort the
+// results. The number of the records and the size of the values are still visible in the db file.
+func WithKeyEncryption(buckets ...string) Option {
+	return func(dbm *DBManager) {
+		dbm.keyEncryption = true
+		dbm.keyEncryptionBuckets = buckets
+	}
+}
+
+// The checkKeyEncryption function returns ErrSecretInvalid if the key encryption is set but the db, or
+// one of the buckets given to WithKeyEncryption, has no keyring to keep the key of the hashes with
+func (dbm *DBManager) checkKeyEncryption() error {
+	if !dbm.keyEncryption {
+		return nil
+	}
+
+	buckets := dbm.keyEncryptionBuckets
+	if len(buckets) == 0 {
+		buckets = []string{""}
+	}
+	for _, bucket := range buckets {
+		keys, err := dbm.keysFor(bucket)
+		if err != nil {
+			return err
+		}
+		if keys == nil || keys.keyHash == nil {
+			return ErrSecretInvalid
+		}
+	}
+	return nil
+}
+
+// The encryptsKeys function returns true if the keys of the bucket are encrypted
+func (dbm *DBManager) encryptsKeys(keys *keyring, bucket string) bool {
+	if !dbm.keyEncryption || keys == nil || keys.keyHash == nil {
+		return false
+	}
+	if len(dbm.keyEncryptionBuckets) == 0 {
+		return true
+	}
+	for _, name := range dbm.keyEncryptionBuckets {
+		if name == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// The hashKey function returns the keyed hash stored in bolt for the key of the record
+func hashKey(hk []byte, bucket string, key []byte) []byte {
+	mac := hmac.New(sha256.New, hk)
+	mac.Write(bindTo(bucket, key))
+	return mac.Sum(nil)
+}
+
+// The loadMetaKey function returns the random key stored in the metadata record, the key is generated and
+// stored encrypted with the primary cryptor if the db doesn't have one
+func (dbm *DBManager) loadMetaKey(keys *keyring, name string) (key []byte, err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	binding := bindTo(metaBucket, []byte(name))
+	load := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		if v := bkt.Get([]byte(name)); v != nil {
+			dec, _, err := openValue(keys, append([]byte(nil), v...), binding)
+			key = dec
+			return err
+		}
+
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return err
+		}
+
+		enc, err := sealValue(keys.primary, key, binding, 0)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(name), enc)
+	}
+
+	err = dbm.db.update(load)
+	return
+}
+
+// The resealMetaKeys function re-encrypts the random keys in the metadata bucket with the primary cryptor
+func (dbm *DBManager) resealMetaKeys(keys *keyring) (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	reseal := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+
+		sealed := make([]record, 0)
+		cursor := bkt.Cursor()
+		for k, v := cursor.Seek([]byte(metaKeyPrefix)); k != nil && strings.HasPrefix(string(k), metaKeyPrefix); k, v = cursor.Next() {
+			binding := bindTo(metaBucket, k)
+			dec, _, err := openValue(keys, append([]byte(nil), v...), binding)
+			if err != nil {
+				return err
+			}
+
+			enc, err := sealValue(keys.primary, dec, binding, 0)
+			if err != nil {
+				return err
+			}
+			sealed = append(sealed, record{key: append([]byte(nil), k...), value: enc})
+		}
+
+		for _, r := range sealed {
+			if err := bkt.Put(r.key, r.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return dbm.db.update(reseal)
+}
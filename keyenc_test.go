@@ -0,0 +1,132 @@
+package boltsec
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDBMKeyEncryption(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, []string{bucketName, "other"}, WithKeyEncryption(bucketName))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	ids := []string{"a-2", "a-10", "b-1", "a-1"}
+	for _, id := range ids {
+		if err = dbm.Save(bucketName, id, Article{ID: id, Title: "title of " + id}); err != nil {
+			t.Fatalf("save data return err: %s", err)
+		}
+	}
+	if err = dbm.Save("other", "a-1", Article{ID: "a-1"}); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+			for _, id := range ids {
+				if strings.Contains(string(k), id) {
+					t.Errorf("key %s is stored in plain text", id)
+				}
+			}
+			return nil
+		})
+		if tx.Bucket([]byte("other")).Get([]byte("a-1")) == nil {
+			t.Errorf("key of the other bucket is encrypted")
+		}
+		return nil
+	})
+	db.Close()
+
+	keyList, err := dbm.GetKeyList(bucketName, "a-")
+	if err != nil {
+		t.Errorf("GetKeyList return err: %s", err)
+	}
+	if expect := []string{"a-1", "a-10", "a-2"}; !reflect.DeepEqual(keyList, expect) {
+		t.Errorf("GetKeyList return %v, expect: %v", keyList, expect)
+	}
+
+	results, err := dbm.GetByPrefix(bucketName, "a-")
+	if err != nil || len(results) != 3 {
+		t.Errorf("GetByPrefix return %d records, err: %v", len(results), err)
+	}
+
+	gets := []struct {
+		key   string
+		title string
+	}{
+		{"a-1", "title of a-1"},
+		{"a-10", "title of a-10"},
+		{"b", "title of b-1"},
+	}
+	for _, iter := range gets {
+		var bytes []byte
+		if bytes, err = dbm.GetOne(bucketName, iter.key); err != nil {
+			t.Errorf("GetOne %s return err: %s", iter.key, err)
+			continue
+		}
+
+		resNew := new(Article)
+		if err = json.Unmarshal(bytes, resNew); err != nil {
+			t.Errorf("json.Unmarshal return err: %s", err)
+		}
+		if resNew.Title != iter.title {
+			t.Errorf("GetOne %s return Title: %s, expect: %s", iter.key, resNew.Title, iter.title)
+		}
+	}
+
+	if err = dbm.Delete(bucketName, "a-10"); err != nil {
+		t.Errorf("Delete return err: %s", err)
+	}
+
+	if err = dbm.Rekey("secret", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "new", false, []string{bucketName}, WithKeyEncryption(bucketName))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	keyList, err = dbm.GetKeyList(bucketName, "")
+	if err != nil {
+		t.Errorf("GetKeyList after Rekey return err: %s", err)
+	}
+	if expect := []string{"a-1", "a-2", "b-1"}; !reflect.DeepEqual(keyList, expect) {
+		t.Errorf("GetKeyList after Rekey return %v, expect: %v", keyList, expect)
+	}
+}
+
+func TestDBMKeyEncryptionWithoutSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, opts := range [][]Option{
+		{WithKeyEncryption()},
+		{WithKeyEncryption("article")},
+		{WithBucketSecret("article", "secret"), WithKeyEncryption()},
+	} {
+		if _, err := NewDBManager("test.dat", dir, "", false, []string{"article"}, opts...); err != ErrSecretInvalid {
+			t.Errorf("NewDBManager without secret return err: %v, expect: %v", err, ErrSecretInvalid)
+		}
+	}
+
+	if _, err := NewDBManager("test.dat", dir, "", false, []string{"article"}, WithBucketSecret("article", "secret"),
+		WithKeyEncryption("article")); err != nil {
+		t.Errorf("NewDBManager with the bucket secret return err: %v", err)
+	}
+	if _, err := NewDBManager("test.dat", dir, "secret", false, []string{"article", "ref"}, WithBucketSecret("ref", ""),
+		WithKeyEncryption("ref")); err != ErrSecretInvalid {
+		t.Errorf("NewDBManager with the plain text bucket return err: %v, expect: %v", err, ErrSecretInvalid)
+	}
+}
@@ -0,0 +1,163 @@
+package boltsec
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+)
+
+// The name of the metadata record which keeps the wrapped data key
+const dataKeyRecord = "datakey"
+
+const dataKeySize = 32
+
+// KeyProvider is the interface to wrap and unwrap the data key of the db with a key-encryption key,
+// which is kept outside of the db, e.g. in a local file, a KMS or an HSM. With a KeyProvider set by
+// WithKeyProvider, the values are encrypted with a random data key which is stored wrapped in the db,
+// thus rotating the key-encryption key only rewraps the data key, see RewrapKey.
+type KeyProvider interface {
+	// Wrap encrypts the data key with the key-encryption key
+	Wrap(dataKey []byte) ([]byte, error)
+	// Unwrap decrypts the data key wrapped by the Wrap, ErrWrongSecret is expected if the
+	// data key is wrapped with another key-encryption key
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// The fileKeyProvider struct keeps the key-encryption key read from a local file
+type fileKeyProvider struct {
+	kek *aesCryptor
+}
+
+// NewFileKeyProvider returns the KeyProvider with the 32 bytes key-encryption key stored in the file,
+// a random key is generated into the file if it does not exist. The file should be kept apart from the
+// db file, e.g. on another volume or in a secret mount, otherwise wrapping the data key is pointless.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	kek, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		kek = make([]byte, dataKeySize)
+		if _, err = io.ReadFull(rand.Reader, kek); err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(path, kek, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kek) != dataKeySize {
+		return nil, errors.New("invalid key-encryption key size in file " + path)
+	}
+
+	ac, err := newAESCryptorWithKey(nil, kek)
+	if err != nil {
+		return nil, err
+	}
+	return &fileKeyProvider{kek: ac}, nil
+}
+
+// The Wrap function encrypts the data key with AES-GCM in the same envelope as the values
+func (fp *fileKeyProvider) Wrap(dataKey []byte) ([]byte, error) {
+	return sealValue(fp.kek, dataKey, nil, 0)
+}
+
+// The Unwrap function decrypts the data key wrapped by the Wrap function
+func (fp *fileKeyProvider) Unwrap(wrapped []byte) ([]byte, error) {
+	dec, _, err := openValue(newKeyring(fp.kek), append([]byte(nil), wrapped...), nil)
+	if err != nil {
+		return nil, ErrWrongSecret
+	}
+	return dec, nil
+}
+
+// WithKeyProvider sets the KeyProvider to wrap the data key which encrypts the values, the data key is
+// generated when the db is opened with the KeyProvider for the first time. If the secret is also given,
+// the values encrypted with the secret are still readable, and can be re-encrypted with the data key by
+// calling Rekey(secret, secret).
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(dbm *DBManager) {
+		dbm.keyProvider = provider
+	}
+}
+
+// The loadDataKey function returns the data key unwrapped by the KeyProvider, the data key is generated
+// and stored wrapped in the metadata bucket if the db doesn't have one
+func (dbm *DBManager) loadDataKey() (dataKey []byte, err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	var wrapped []byte
+	load := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		if v := bkt.Get([]byte(dataKeyRecord)); v != nil {
+			wrapped = append([]byte(nil), v...)
+			return nil
+		}
+
+		dataKey = make([]byte, dataKeySize)
+		if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+			return err
+		}
+
+		enc, err := dbm.keyProvider.Wrap(dataKey)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(dataKeyRecord), enc)
+	}
+
+	if err = dbm.db.update(load); err != nil || wrapped == nil {
+		return
+	}
+
+	return dbm.keyProvider.Unwrap(wrapped)
+}
+
+// RewrapKey wraps the data key of the db with the new KeyProvider, which is used afterwards. This is
+// how to rotate the key-encryption key, only the wrapped data key is rewritten and the values are kept
+// as they are. It requires the db to be opened with the current KeyProvider by WithKeyProvider.
+// ErrLocked is returned if the db is locked, see Unlock.
+func (dbm *DBManager) RewrapKey(provider KeyProvider) (err error) {
+	dbm.rekeyMu.Lock()
+	defer dbm.rekeyMu.Unlock()
+
+	if _, err = dbm.currentKeys(); err != nil {
+		return
+	}
+	if dbm.keyProvider == nil {
+		return errors.New("the db is not opened with a key provider")
+	}
+
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	rewrap := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		v := bkt.Get([]byte(dataKeyRecord))
+		if v == nil {
+			return errors.New("the db has no data key")
+		}
+
+		dataKey, err := dbm.keyProvider.Unwrap(v)
+		if err != nil {
+			return err
+		}
+
+		enc, err := provider.Wrap(dataKey)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(dataKeyRecord), enc)
+	}
+
+	if err = dbm.db.update(rewrap); err != nil {
+		return
+	}
+
+	dbm.keyProvider = provider
+	return nil
+}
@@ -0,0 +1,95 @@
+package boltsec
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBMKeyProvider(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	provider, err := NewFileKeyProvider(filepath.Join(dir, "kek"))
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider return err: %s", err)
+	}
+
+	dbm, err := NewDBManager("test.dat", dir, "", false, []string{bucketName}, WithKeyProvider(provider))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	//rotate the key-encryption key
+	rotated, err := NewFileKeyProvider(filepath.Join(dir, "kek2"))
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider return err: %s", err)
+	}
+	if err = dbm.RewrapKey(rotated); err != nil {
+		t.Fatalf("RewrapKey return err: %s", err)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "", false, []string{bucketName}, WithKeyProvider(provider)); err != ErrWrongSecret {
+		t.Errorf("NewDBManager with the old key provider return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+
+	//the same key file is loaded by another provider
+	reloaded, err := NewFileKeyProvider(filepath.Join(dir, "kek2"))
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider return err: %s", err)
+	}
+	dbm, err = NewDBManager("test.dat", dir, "", false, []string{bucketName}, WithKeyProvider(reloaded))
+	if err != nil {
+		t.Fatalf("NewDBManager with the rotated key provider return err: %s", err)
+	}
+
+	var bytes []byte
+	if bytes, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Fatalf("GetOne return err: %s", err)
+	}
+
+	resNew := new(Article)
+	if err = json.Unmarshal(bytes, resNew); err != nil {
+		t.Errorf("json.Unmarshal return err: %s", err)
+	}
+	if resNew.Title != data.Title {
+		t.Errorf("returned Title is not equal: new:%s, org: %s", resNew.Title, data.Title)
+	}
+
+	//the keys are built with either provider while the data key is rewrapped
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if err := dbm.SetSecret(""); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i, p := range []KeyProvider{provider, reloaded, provider, reloaded} {
+		if err = dbm.RewrapKey(p); err != nil {
+			t.Errorf("RewrapKey %d return err: %s", i, err)
+		}
+	}
+	if err = <-done; err != nil {
+		t.Errorf("SetSecret while RewrapKey return err: %s", err)
+	}
+
+	dbm.Lock()
+	if err = dbm.RewrapKey(provider); err != ErrLocked {
+		t.Errorf("RewrapKey of locked db return err: %v, expect: %v", err, ErrLocked)
+	}
+	if err = dbm.Unlock(nil); err != nil {
+		t.Fatalf("Unlock return err: %s", err)
+	}
+	if err = dbm.RewrapKey(provider); err != nil {
+		t.Errorf("RewrapKey after Unlock return err: %s", err)
+	}
+}
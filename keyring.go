@@ -0,0 +1,164 @@
+package boltsec
+
+// The keyring struct keeps the cryptors which can be used by the read paths, the envelope
+// header of a stored value tells which of them encrypted the value. A keyring is never
+// modified once it is in use by the DBManager, a new one is built and swapped in instead.
+type keyring struct {
+	// primary encrypts all the new values
+	primary Cryptor
+	// legacy decrypts the values written before the envelope header, which carry no key identifier
+	legacy *aesCryptor
+	// plaintext is true when the values without the envelope header are stored in plain text,
+	// which is the case while a Rekey encrypts a db which is not encrypted yet
+	plaintext bool
+	// noLegacy rejects the values without the envelope header, see WithoutLegacyFormat
+	noLegacy bool
+	keys     map[keyringKey]Cryptor
+	// keyHash is the key to hash the keys of the records, see WithKeyEncryption
+	keyHash []byte
+	// indexKey is the key of the blind indexes, see WithIndex
+	indexKey []byte
+	// integrityKey is the key of the digests of the buckets, see WithIntegrity
+	integrityKey []byte
+	// tenants keeps the primary cryptor of the data key of each tenant, see SaveTenant
+	tenants map[string]Cryptor
+	// tenantKeys keeps the keys of the tenants, which are not changed by the Rekey
+	tenantKeys map[keyringKey]string
+	// shredded keeps the keys of the tenants destroyed by ShredTenant
+	shredded map[keyringKey]bool
+}
+
+type keyringKey struct {
+	suite Suite
+	id    KeyID
+}
+
+// The newKeyring function returns a keyring with the primary cryptor, which is also used
+// for the legacy values if it is the AES cryptor
+func newKeyring(primary Cryptor) *keyring {
+	kr := &keyring{
+		primary: primary,
+		keys:    make(map[keyringKey]Cryptor),
+	}
+	kr.legacy, _ = primary.(*aesCryptor)
+	kr.add(primary)
+	return kr
+}
+
+// The add function adds the cryptor for reading, the cryptor already in the keyring is kept
+func (kr *keyring) add(c Cryptor) {
+	k := keyringKey{c.Suite(), c.KeyID()}
+	if _, ok := kr.keys[k]; !ok {
+		kr.keys[k] = c
+	}
+}
+
+// The lookup function returns the cryptor for the suite and the key identifier, ErrShredded is returned
+// if the key belonged to a shredded tenant
+func (kr *keyring) lookup(suite Suite, id KeyID) (Cryptor, error) {
+	if c, ok := kr.keys[keyringKey{suite, id}]; ok {
+		return c, nil
+	}
+	if kr.shredded[keyringKey{suite, id}] {
+		return nil, ErrShredded
+	}
+
+	for k := range kr.keys {
+		if k.suite == suite {
+			return nil, ErrUnknownKey
+		}
+	}
+	return nil, ErrUnknownSuite
+}
+
+// The merge function adds the cryptors of the other keyring for reading, the legacy values are
+// decrypted by the legacy cryptor of the other keyring if it has one
+func (kr *keyring) merge(other *keyring) {
+	if other.legacy != nil {
+		kr.legacy = other.legacy
+	}
+	for _, c := range other.keys {
+		kr.add(c)
+	}
+}
+
+// The close function zeroes the keys of the keyring, including the ones of the tenants. The cryptors
+// set by WithCryptor and WithBucketCryptor belong to the caller and are not closed
+func (kr *keyring) close() {
+	for _, c := range kr.keys {
+		switch c := c.(type) {
+		case *aesCryptor:
+			c.Close()
+		case *chachaCryptor:
+			c.Close()
+		}
+	}
+	if kr.legacy != nil {
+		kr.legacy.Close()
+	}
+	zero(kr.keyHash)
+	zero(kr.indexKey)
+	zero(kr.integrityKey)
+}
+
+// The isTenantKey function returns true if the key belongs to a tenant, including a shredded one
+func (kr *keyring) isTenantKey(suite Suite, id KeyID) bool {
+	k := keyringKey{suite, id}
+	_, ok := kr.tenantKeys[k]
+	return ok || kr.shredded[k]
+}
+
+// The inherit function copies the random keys and the tenant keys of the other keyring, which are kept
+// when the keyring is rebuilt for another secret
+func (kr *keyring) inherit(other *keyring) {
+	kr.keyHash = other.keyHash
+	kr.indexKey = other.indexKey
+	kr.integrityKey = other.integrityKey
+	for tenant, c := range other.tenants {
+		if kr.tenants == nil {
+			kr.tenants = make(map[string]Cryptor)
+		}
+		kr.tenants[tenant] = c
+	}
+	for k, tenant := range other.tenantKeys {
+		kr.addTenantKey(k, other.keys[k], tenant)
+	}
+	for k := range other.shredded {
+		kr.shred(k)
+	}
+}
+
+// The clone function returns a copy of the keyring which can be changed without affecting the keyring in use
+func (kr *keyring) clone() *keyring {
+	c := &keyring{
+		primary:   kr.primary,
+		legacy:    kr.legacy,
+		plaintext: kr.plaintext,
+		noLegacy:  kr.noLegacy,
+		keys:      make(map[keyringKey]Cryptor, len(kr.keys)),
+	}
+	for k, v := range kr.keys {
+		c.keys[k] = v
+	}
+	c.inherit(kr)
+	return c
+}
+
+// The addTenantKey function adds the cryptor of the data key of the tenant for reading
+func (kr *keyring) addTenantKey(k keyringKey, c Cryptor, tenant string) {
+	if kr.tenantKeys == nil {
+		kr.tenantKeys = make(map[keyringKey]string)
+	}
+	kr.keys[k] = c
+	kr.tenantKeys[k] = tenant
+}
+
+// The shred function removes the key of a shredded tenant, the values encrypted with it return ErrShredded
+func (kr *keyring) shred(k keyringKey) {
+	if kr.shredded == nil {
+		kr.shredded = make(map[keyringKey]bool)
+	}
+	delete(kr.keys, k)
+	delete(kr.tenantKeys, k)
+	kr.shredded[k] = true
+}
@@ -0,0 +1,123 @@
+package boltsec
+
+// WithSecretBytes sets the secret of the db as bytes instead of the secret argument of NewDBManager, which
+// must be "" then. Unlike a string, the copy of the secret kept by the DBManager is zeroed by Lock and Close.
+func WithSecretBytes(secret []byte) Option {
+	return func(dbm *DBManager) {
+		dbm.secret = append([]byte(nil), secret...)
+	}
+}
+
+// WithBucketSecretBytes sets the secret of the bucket as bytes, the same as WithBucketSecret. The secret is
+// copied, thus the caller can zero it afterwards.
+func WithBucketSecretBytes(bucket string, secret []byte) Option {
+	return func(dbm *DBManager) {
+		dbm.bucketConfig(bucket).secret = append([]byte(nil), secret...)
+	}
+}
+
+// Lock zeroes the secret and the keys derived from it in the memory, including the keys of the buckets and
+// of the tenants, and all the db operations return ErrLocked until Unlock is called, e.g. when a long-running
+// service is idle. The new operations return ErrLocked at once, and Lock waits for the operations already
+// running to complete before the keys are zeroed, thus it must not be called in the function of Update or View.
+//
+// The secrets given as strings, i.e. the secret argument of NewDBManager, SetSecret and WithBucketSecret,
+// cannot be zeroed, use WithSecretBytes and Unlock for the secret of the db instead. The secrets of the
+// buckets are kept to load the keys of the buckets again at Unlock. The expanded keys inside the ciphers
+// of the Go crypto packages cannot be zeroed either, they are only released.
+func (dbm *DBManager) Lock() {
+	dbm.rekeyMu.Lock()
+	dbm.keysMu.Lock()
+
+	secret, keys, bucketKeys := dbm.secret, dbm.keys, dbm.bucketKeys
+	dbm.secret = nil
+	dbm.keys = nil
+	dbm.bucketKeys = nil
+	dbm.locked = true
+
+	dbm.keysMu.Unlock()
+	dbm.rekeyMu.Unlock()
+
+	//the operations already running may still use the keys, they hold the db until they are completed
+	dbm.waitIdle()
+
+	if keys != nil {
+		keys.close()
+	}
+	for _, keys := range bucketKeys {
+		if keys != nil {
+			keys.close()
+		}
+	}
+	zero(secret)
+}
+
+// Unlock loads the keys of the db from the secret, and of the buckets with their own secret or cryptor, after
+// a Lock or Close. The secret is copied, thus the caller can zero it afterwards. The same as SetSecret,
+// ErrWrongSecret is returned and the db stays locked if the db is encrypted with another secret.
+func (dbm *DBManager) Unlock(secret []byte) (err error) {
+	dbm.rekeyMu.Lock()
+	defer dbm.rekeyMu.Unlock()
+
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	key := append([]byte(nil), secret...)
+
+	var keys *keyring
+	if keys, err = dbm.buildKeys(key); err != nil {
+		return
+	}
+	if err = dbm.prepareKeys(keys, ""); err != nil {
+		return
+	}
+
+	bucketKeys, err := dbm.buildBucketKeys()
+	if err != nil {
+		return
+	}
+
+	dbm.keysMu.Lock()
+	defer dbm.keysMu.Unlock()
+
+	dbm.secret = key
+	dbm.keys = keys
+	dbm.bucketKeys = bucketKeys
+	dbm.locked = false
+	return nil
+}
+
+// Close locks the db, see Lock, and closes the db file even if the batch mode is on. The db can be used
+// again after Unlock, the db file is opened again by the next db operation.
+func (dbm *DBManager) Close() (err error) {
+	dbm.Lock()
+
+	dbm.dbMu.Lock()
+	defer dbm.dbMu.Unlock()
+
+	dbm.batchMode = false
+	if dbm.dbRefs == 0 && dbm.db != nil {
+		err = dbm.db.Close()
+		dbm.db = nil
+	}
+	return
+}
+
+// The waitIdle function waits until no operation uses the db
+func (dbm *DBManager) waitIdle() {
+	dbm.dbMu.Lock()
+	defer dbm.dbMu.Unlock()
+
+	for dbm.dbRefs > 0 {
+		dbm.idleCond().Wait()
+	}
+}
+
+// The zero function overwrites the bytes with zeros
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
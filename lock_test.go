@@ -0,0 +1,166 @@
+package boltsec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDBMLock(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "tenant"}
+	opts := []Option{WithBucketSecret("tenant", "tenant-secret")}
+
+	secret := []byte("secret")
+	dbm, err := NewDBManager("test.dat", dir, "", true, buckets, append(opts, WithSecretBytes(secret))...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if !bytes.Equal(secret, []byte("secret")) {
+		t.Errorf("NewDBManager changed the secret of the caller")
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	for _, bucket := range buckets {
+		if err = dbm.Save(bucket, data.ID, data); err != nil {
+			t.Fatalf("Save to %s return err: %s", bucket, err)
+		}
+	}
+
+	keys, _ := dbm.currentKeys()
+	primary := keys.primary.(*aesCryptor)
+	dbm.Lock()
+
+	if !bytes.Equal(primary.key, make([]byte, len(primary.key))) || !bytes.Equal(primary.rawkey, make([]byte, len(primary.rawkey))) {
+		t.Errorf("Lock doesn't zero the keys")
+	}
+	if _, err = dbm.GetOne("article", data.ID); err != ErrLocked {
+		t.Errorf("GetOne of locked db return err: %v, expect: %v", err, ErrLocked)
+	}
+	if err = dbm.Save("tenant", data.ID, data); err != ErrLocked {
+		t.Errorf("Save to locked db return err: %v, expect: %v", err, ErrLocked)
+	}
+	if err = dbm.SetSecret("secret"); err != ErrLocked {
+		t.Errorf("SetSecret of locked db return err: %v, expect: %v", err, ErrLocked)
+	}
+
+	if err = dbm.Unlock([]byte("wrong")); err != ErrWrongSecret {
+		t.Errorf("Unlock with wrong secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+	if _, err = dbm.GetOne("article", data.ID); err != ErrLocked {
+		t.Errorf("GetOne after failed Unlock return err: %v, expect: %v", err, ErrLocked)
+	}
+
+	if err = dbm.Unlock(secret); err != nil {
+		t.Fatalf("Unlock return err: %s", err)
+	}
+	for _, bucket := range buckets {
+		if res, err := dbm.GetOne(bucket, data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+			t.Errorf("GetOne %s after Unlock return %s, err: %v", bucket, res, err)
+		}
+	}
+
+	//the db file is closed even in the batch mode
+	if err = dbm.Close(); err != nil {
+		t.Fatalf("Close return err: %s", err)
+	}
+	if dbm.db != nil {
+		t.Errorf("Close doesn't close the db file")
+	}
+	if err = dbm.Unlock(secret); err != nil {
+		t.Fatalf("Unlock after Close return err: %s", err)
+	}
+	if res, err := dbm.GetOne("tenant", data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+		t.Errorf("GetOne after Close and Unlock return %s, err: %v", res, err)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithSecretBytes(secret)); err != ErrSecretInvalid {
+		t.Errorf("NewDBManager with secret and secret bytes return err: %v, expect: %v", err, ErrSecretInvalid)
+	}
+}
+
+func TestDBMLockRunning(t *testing.T) {
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"article"}, WithKeyEncryption())
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	//the Lock waits for the running Update, which keeps using the keys it has read
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	locked := make(chan struct{})
+	update := func(tx *Tx) error {
+		if _, err := tx.Get("article", data.ID); err != ErrNotFound {
+			return err
+		}
+		go func() {
+			dbm.Lock()
+			close(locked)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case <-locked:
+			t.Errorf("Lock returns before the running Update is completed")
+		default:
+		}
+		return tx.Put("article", data.ID, data)
+	}
+	if err = dbm.Update(update); err != nil {
+		t.Fatalf("Update return err: %s", err)
+	}
+	<-locked
+
+	if err = dbm.Save("article", "ID-0002", data); err != ErrLocked {
+		t.Errorf("Save after Lock return err: %v, expect: %v", err, ErrLocked)
+	}
+	if err = dbm.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("Unlock return err: %s", err)
+	}
+	if res, err := dbm.Get("article", data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+		t.Errorf("Get record saved while locking return %s, err: %v", res, err)
+	}
+}
+
+func TestDBMSecretBytes(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "tenant"}
+
+	secret, bucketSecret := []byte("secret"), []byte("tenant-secret")
+	dbm, err := NewDBManager("test.dat", dir, "", false, buckets, WithSecretBytes(secret), WithBucketSecretBytes("tenant", bucketSecret))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	zero(bucketSecret)
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	for _, bucket := range buckets {
+		if err = dbm.Save(bucket, data.ID, data); err != nil {
+			t.Fatalf("Save to %s return err: %s", bucket, err)
+		}
+	}
+
+	newSecret := []byte("new")
+	if err = dbm.RekeyBytes(secret, newSecret, nil); err != nil {
+		t.Fatalf("RekeyBytes return err: %s", err)
+	}
+	zero(newSecret)
+	if res, err := dbm.GetOne("article", data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+		t.Errorf("GetOne after RekeyBytes return %s, err: %v", res, err)
+	}
+
+	shares, err := SplitSecretBytes([]byte("new"), 3, 2)
+	if err != nil {
+		t.Fatalf("SplitSecretBytes return err: %s", err)
+	}
+	dbm, err = NewDBManager("test.dat", dir, "", false, buckets, WithSecretShares(shares[0], shares[2]), WithBucketSecret("tenant", "tenant-secret"))
+	if err != nil {
+		t.Fatalf("NewDBManager with shares return err: %s", err)
+	}
+	for _, bucket := range buckets {
+		if res, err := dbm.GetOne(bucket, data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+			t.Errorf("GetOne %s after reopen return %s, err: %v", bucket, res, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package boltsec
+
+import (
+	"errors"
+)
+
+// The byte which marks the end of the value in a padded value, it is followed by zeros up to the padded size
+const paddingMark = 0x80
+
+// The smallest size of a value padded by PadPowerOfTwo
+const minPaddedSize = 32
+
+// Padding returns the padded size of a value of n bytes, which must be greater than n. The values padded
+// to the same size cannot be told apart by their length in the db file.
+type Padding func(n int) int
+
+// PadPowerOfTwo pads the value to the next power of two, at least 32 bytes. The values of a similar length
+// are indistinguishable, at the cost of up to doubling the size of the values
+func PadPowerOfTwo(n int) int {
+	size := minPaddedSize
+	for size <= n {
+		size <<= 1
+	}
+	return size
+}
+
+// PadBlock returns the Padding to the next multiple of the block size, which hides the length of the values
+// up to the block size, e.g. the true or false of a field, at the cost of less than a block for each value.
+// A size less than 1 is replaced by 32 bytes, the smallest size of PadPowerOfTwo.
+func PadBlock(size int) Padding {
+	if size < 1 {
+		size = minPaddedSize
+	}
+	return func(n int) int {
+		return (n/size + 1) * size
+	}
+}
+
+// WithPadding pads the values before they are encrypted by Save, so that the length of the value stored in
+// the db file doesn't reveal the exact length of the data, e.g. PadPowerOfTwo or PadBlock(256). The padding
+// is stripped by the read paths, and the values saved without the padding stay readable. The values are
+// only padded if they are encrypted.
+func WithPadding(padding Padding) Option {
+	return func(dbm *DBManager) {
+		dbm.padding = padding
+	}
+}
+
+// The pad function appends the paddingMark and the zeros to the value up to the padded size
+func pad(value []byte, padding Padding) ([]byte, error) {
+	size := padding(len(value))
+	if size <= len(value) {
+		return nil, errors.New("invalid padded size")
+	}
+
+	output := make([]byte, size)
+	copy(output, value)
+	output[len(value)] = paddingMark
+	return output, nil
+}
+
+// The unpad function returns the value without the padding
+func unpad(value []byte) ([]byte, error) {
+	for i := len(value) - 1; i >= 0; i-- {
+		switch value[i] {
+		case 0:
+		case paddingMark:
+			return value[:i], nil
+		default:
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return nil, errors.New("invalid padding")
+}
@@ -0,0 +1,98 @@
+package boltsec
+
+import (
+	"bytes"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func TestPad(t *testing.T) {
+	//the block size less than 1 is replaced by 32 bytes
+	for _, padding := range []Padding{PadPowerOfTwo, PadBlock(16), PadBlock(0), PadBlock(-8)} {
+		for _, value := range [][]byte{{}, []byte("true"), []byte("false"), bytes.Repeat([]byte{0x80}, 31), make([]byte, 32)} {
+			padded, err := pad(value, padding)
+			if err != nil {
+				t.Fatalf("pad return err: %s", err)
+			}
+			if len(padded) <= len(value) || len(padded)%16 != 0 {
+				t.Errorf("pad %d bytes to %d bytes", len(value), len(padded))
+			}
+
+			res, err := unpad(padded)
+			if err != nil || !bytes.Equal(res, value) {
+				t.Errorf("unpad return %x, err: %v, expect: %x", res, err, value)
+			}
+		}
+	}
+
+	if PadPowerOfTwo(4) != PadPowerOfTwo(5) || PadPowerOfTwo(40) != 64 {
+		t.Errorf("PadPowerOfTwo return %d, %d, %d", PadPowerOfTwo(4), PadPowerOfTwo(5), PadPowerOfTwo(40))
+	}
+	if PadBlock(0)(40) != 64 || PadBlock(-8)(0) != 32 {
+		t.Errorf("PadBlock of invalid size return %d, %d", PadBlock(0)(40), PadBlock(-8)(0))
+	}
+	if _, err := unpad(make([]byte, 16)); err == nil {
+		t.Errorf("unpad without the padding mark return no error")
+	}
+}
+
+func TestDBMPadding(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article"}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	//the values saved without the padding stay readable
+	data := Article{ID: "ID-0000", Title: "saved without padding"}
+	if err = dbm.Save("article", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithPadding(PadBlock(128)), WithKeyEncryption())
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	articles := []Article{{ID: "ID-0001", Title: "short"}, {ID: "ID-0002", Title: "a longer title of the article"}}
+	for _, a := range articles {
+		if err = dbm.Save("article", a.ID, a); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+
+	for _, a := range append(articles, data) {
+		res, err := dbm.GetOne("article", a.ID)
+		if err != nil || !bytes.Contains(res, []byte(a.Title)) {
+			t.Errorf("GetOne %s return %s, err: %v", a.ID, res, err)
+		}
+	}
+
+	keys, err := dbm.GetKeyList("article", "ID-")
+	if err != nil || len(keys) != 3 {
+		t.Errorf("GetKeyList return %v, err: %v", keys, err)
+	}
+
+	dbm.SetBatchMode(false)
+	db, err := bolt.Open(filepath.Join(dir, "test.dat"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open return err: %s", err)
+	}
+	defer db.Close()
+
+	sizes := make(map[int]int)
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("article")).ForEach(func(k, v []byte) error {
+			sizes[len(v)]++
+			return nil
+		})
+	})
+	//the value saved without the padding and the two padded values of the same size
+	if len(sizes) != 2 {
+		t.Errorf("the padded values have different sizes: %v", sizes)
+	}
+}
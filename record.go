@@ -0,0 +1,284 @@
+package boltsec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+)
+
+// The record struct is a decrypted key/value pair of a bucket, and the codec of the value
+type record struct {
+	key   []byte
+	value []byte
+	codec CodecID
+}
+
+// The storageKey function returns the key stored in bolt for the key of the record, which is the keyed
+// hash of the key if the keys of the bucket are encrypted
+func (dbm *DBManager) storageKey(keys *keyring, bucket string, key []byte) []byte {
+	if !dbm.encryptsKeys(keys, bucket) {
+		return key
+	}
+	return hashKey(keys.keyHash, bucket, key)
+}
+
+// The sealRecord function returns the key and the value stored in bolt for the record. If the secret is set,
+// the value is encrypted and bound to the stored key; if the keys of the bucket are encrypted as well, the
+// key is embedded in the encrypted value so that the read paths can return it. The value is prefixed by
+// the identifier of the codec of the bucket, and is compressed and padded before it is encrypted if they
+// are set by WithCompression and WithPadding. The value is encrypted with the cryptor if it is not nil,
+// e.g. the one of a tenant, otherwise with the primary cryptor of the keyring
+func (dbm *DBManager) sealRecord(keys *keyring, c Cryptor, bucket string, key, value []byte) (k, v []byte, err error) {
+	if keys == nil {
+		return key, value, nil
+	}
+
+	flags := flagCodec
+	value = tagCodec(dbm.codecFor(bucket).ID(), value)
+	k = key
+	if dbm.encryptsKeys(keys, bucket) {
+		k = hashKey(keys.keyHash, bucket, key)
+		value = embedKey(key, value)
+		flags |= flagKeyEmbedded
+	}
+
+	if dbm.compression {
+		compressed, err := compress(value, dbm.compressionLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(compressed) < len(value) {
+			value = compressed
+			flags |= flagCompressed
+		}
+	}
+
+	if dbm.padding != nil {
+		if value, err = pad(value, dbm.padding); err != nil {
+			return nil, nil, err
+		}
+		flags |= flagPadded
+	}
+
+	if c == nil {
+		c = keys.primary
+	}
+
+	//encrypt the content before store in the db
+	if v, err = sealValue(c, value, bindTo(bucket, k), flags); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrEncrypt, err)
+	}
+	return k, v, nil
+}
+
+// The putRecord function stores the sealed record of the stored key into the bucket, and updates the blind
+// indexes and the digest of the bucket with it. The value is the plain value of the record, see sealRecord
+func (dbm *DBManager) putRecord(tx *boltsecTx, keys *keyring, bkt *bolt.Bucket, bucket string, k, v, value []byte) error {
+	if err := bkt.Put(k, v); err != nil {
+		return err
+	}
+	if err := dbm.updateIndex(tx, keys, bucket, k, value); err != nil {
+		return err
+	}
+	return dbm.updateDigest(tx, keys, bucket, k, v)
+}
+
+// The deleteRecord function deletes the record of the stored key from the bucket, the blind indexes and the
+// digest of the bucket
+func (dbm *DBManager) deleteRecord(tx *boltsecTx, keys *keyring, bkt *bolt.Bucket, bucket string, k []byte) error {
+	if err := bkt.Delete(k); err != nil {
+		return err
+	}
+	if err := dbm.updateIndex(tx, keys, bucket, k, nil); err != nil {
+		return err
+	}
+	return dbm.updateDigest(tx, keys, bucket, k, nil)
+}
+
+// The openRecord function returns the key and the decrypted value of the record stored in bolt, the errors
+// are returned as a DecryptError
+func openRecord(keys *keyring, bucket string, k, v []byte) (key, value []byte, err error) {
+	r, err := openCodecRecord(keys, bucket, k, v)
+	return r.key, r.value, err
+}
+
+// The openCodecRecord function returns the record stored in bolt with the codec of its value, which is 0
+// if the value has none, e.g. it is in plain text
+func openCodecRecord(keys *keyring, bucket string, k, v []byte) (r record, err error) {
+	value, flags, err := decryptValue(keys, bucket, k, v)
+	if err != nil {
+		return r, err
+	}
+	if flags&flagBlob != 0 {
+		return r, decryptError(bucket, k, ErrTampered)
+	}
+
+	if flags&flagPadded != 0 {
+		if value, err = unpad(value); err != nil {
+			return r, decryptError(bucket, k, err)
+		}
+	}
+
+	if flags&flagCompressed != 0 {
+		if value, err = decompress(value); err != nil {
+			return r, decryptError(bucket, k, err)
+		}
+	}
+
+	r.key = append([]byte(nil), k...)
+	if flags&flagKeyEmbedded != 0 {
+		if r.key, value, err = extractKey(value); err != nil {
+			return record{}, decryptError(bucket, k, err)
+		}
+	}
+
+	r.value = value
+	if flags&flagCodec != 0 {
+		if r.codec, r.value, err = untagCodec(value); err != nil {
+			return record{}, decryptError(bucket, k, err)
+		}
+	}
+	return r, nil
+}
+
+// The decodeRecord function returns the record stored in bolt, the codec of a value which has none is
+// the codec of the bucket
+func (dbm *DBManager) decodeRecord(keys *keyring, bucket string, k, v []byte) (record, error) {
+	r, err := openCodecRecord(keys, bucket, k, v)
+	if err == nil && r.codec == 0 {
+		r.codec = dbm.codecFor(bucket).ID()
+	}
+	return r, err
+}
+
+// The getRecord function returns the record of the key in one transaction, ErrNotFound is returned if
+// the bucket has no record of the key, e.g. the key is a blob
+func (dbm *DBManager) getRecord(bucket, key string) (r record, err error) {
+	get := func(tx *Tx) error {
+		r, err = tx.record(bucket, key)
+		return err
+	}
+
+	if err = dbm.View(get); err != nil {
+		return record{}, err
+	}
+	return r, nil
+}
+
+// The findRecords function returns the records with the prefix in one transaction, see seekRecords
+func (dbm *DBManager) findRecords(bucket, prefix string, limit int) ([]record, error) {
+	var err error
+	var results []record
+
+	if err = dbm.openDB(); err != nil {
+		return nil, err
+	}
+	defer dbm.closeDB()
+
+	keys, err := dbm.keysFor(bucket)
+	if err != nil {
+		return nil, err
+	}
+	seek := func(tx *boltsecTx) error {
+		results, err = dbm.seekRecords(tx, keys, bucket, []byte(prefix), limit, false)
+		return err
+	}
+
+	if err = dbm.db.view(seek); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// The seekRecords function returns the records with the prefix in the order of the keys, at most limit
+// records are returned if the limit is greater than 0. The values are not decrypted if keysOnly is true,
+// unless the keys of the bucket are encrypted, in which case all the values of the bucket are decrypted
+// to find the keys with the prefix. ErrBucketReserved is returned for the buckets reserved by the package
+func (dbm *DBManager) seekRecords(tx *boltsecTx, keys *keyring, bucket string, prefix []byte, limit int, keysOnly bool) ([]record, error) {
+	if isReserved(bucket) {
+		return nil, ErrBucketReserved
+	}
+
+	bkt := tx.Bucket([]byte(bucket))
+
+	if bkt == nil {
+		return nil, ErrBucketNotFound
+	}
+
+	results := make([]record, 0)
+
+	if dbm.encryptsKeys(keys, bucket) {
+		//the exact key is the first one with the prefix
+		if limit == 1 {
+			if v := bkt.Get(hashKey(keys.keyHash, bucket, prefix)); v != nil {
+				r, err := dbm.decodeRecord(keys, bucket, hashKey(keys.keyHash, bucket, prefix), v)
+				if err != nil {
+					return nil, err
+				}
+				return append(results, r), nil
+			}
+		}
+
+		err := bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			r, err := dbm.decodeRecord(keys, bucket, k, v)
+			if err == nil && bytes.HasPrefix(r.key, prefix) {
+				results = append(results, r)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(results, func(i, j int) bool { return bytes.Compare(results[i].key, results[j].key) < 0 })
+		if limit > 0 && len(results) > limit {
+			results = results[:limit]
+		}
+		return results, nil
+	}
+
+	cursor := bkt.Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		if limit > 0 && len(results) == limit {
+			break
+		}
+		if v == nil {
+			//nested bucket
+			continue
+		}
+
+		if keysOnly {
+			results = append(results, record{key: append([]byte(nil), k...)})
+			continue
+		}
+
+		r, err := dbm.decodeRecord(keys, bucket, k, v)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// The embedKey function prefixes the value with the key
+func embedKey(key, value []byte) []byte {
+	output := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(key)+len(value))
+	output = append(output[:binary.PutUvarint(output, uint64(len(key)))], key...)
+	return append(output, value...)
+}
+
+// The extractKey function splits the value prefixed by the embedKey function
+func extractKey(data []byte) (key, value []byte, err error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return nil, nil, ErrUnknownFormat
+	}
+	return data[n : n+int(size)], data[n+int(size):], nil
+}
@@ -0,0 +1,263 @@
+package boltsec
+
+import (
+	"errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RekeyBatchSize is the number of values visited in one transaction by the Rekey function,
+// a smaller size makes each transaction shorter so that other writes are blocked for less time
+var RekeyBatchSize = 1000
+
+// The RekeyProgress struct is passed to the progress function of Rekey after each committed batch
+type RekeyProgress struct {
+	// Bucket is the name of the bucket being rekeyed
+	Bucket string
+	// Total is the number of records and blobs in all the buckets when the Rekey started
+	Total int
+	// Rekeyed is the number of values re-encrypted with the new secret
+	Rekeyed int
+	// Skipped is the number of values which were already encrypted with the new secret,
+	// e.g. by an interrupted Rekey or by a Save while the Rekey is running
+	Skipped int
+}
+
+// Rekey re-encrypts all the values in every bucket from the oldSecret to the newSecret, except the buckets
+// with their own secret or cryptor set by WithBucketSecret or WithBucketCryptor. The oldSecret can be "" to
+// encrypt a db which is not encrypted yet. If the cryptor is set by WithCryptor or the data key by
+// WithKeyProvider, the values are re-encrypted with it instead, thus the newSecret can also be "".
+//
+// The values are rewritten in batches of RekeyBatchSize, each in its own transaction, and the progress
+// function (if not nil) is called after each batch. The db stays usable while the Rekey is running: the
+// values are read with either secret and the new values are saved with the newSecret.
+//
+// If the Rekey is interrupted, the db contains values of both secrets and is still verified against
+// the oldSecret when it is opened; call the Rekey again with the same secrets to resume, the values
+// already encrypted with the newSecret are skipped. The values saved by the earlier versions are bound to
+// their bucket and key by the Rekey, thus Rekey(secret, secret) is a way to upgrade them.
+func (dbm *DBManager) Rekey(oldSecret, newSecret string, progress func(RekeyProgress)) (err error) {
+	return dbm.RekeyBytes([]byte(oldSecret), []byte(newSecret), progress)
+}
+
+// RekeyBytes re-encrypts all the values from the oldSecret to the newSecret given as bytes, the same as
+// Rekey. The secrets are copied, thus the caller can zero them afterwards.
+func (dbm *DBManager) RekeyBytes(oldSecret, newSecret []byte, progress func(RekeyProgress)) (err error) {
+	oldSecret = append([]byte(nil), oldSecret...)
+	newSecret = append([]byte(nil), newSecret...)
+
+	dbm.rekeyMu.Lock()
+	defer dbm.rekeyMu.Unlock()
+
+	current, err := dbm.currentKeys()
+	if err != nil {
+		return err
+	}
+	if !dbm.isSecret(oldSecret) && !dbm.isSecret(newSecret) {
+		return ErrWrongSecret
+	}
+
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	var newKeys, oldKeys *keyring
+	if newKeys, err = dbm.buildKeys(newSecret); err != nil {
+		return
+	}
+	if newKeys == nil {
+		return ErrSecretInvalid
+	}
+	if oldKeys, err = dbm.buildKeys(oldSecret); err != nil {
+		return
+	}
+	primary := newKeys.primary
+
+	//the values can be encrypted with either secret until all of them are rekeyed
+	if oldKeys == nil && dbm.keyEncryption {
+		return errors.New("cannot rekey a plain text db with the key encryption")
+	}
+
+	keys := newKeyring(primary)
+	if keys.noLegacy, err = dbm.rejectsLegacy(); err != nil {
+		return
+	}
+	keys.merge(newKeys)
+	if oldKeys != nil {
+		keys.merge(oldKeys)
+	} else {
+		keys.plaintext = true
+	}
+	if current != nil {
+		keys.inherit(current)
+	} else if err = dbm.loadMetaKeys(keys, ""); err != nil {
+		//a plain text db has no random keys, they are created with the new secret
+		return
+	}
+	dbm.setKeys(newSecret, keys)
+
+	var p RekeyProgress
+	var names [][]byte
+
+	list := func(tx *boltsecTx) error {
+		return tx.ForEach(func(name []byte, bkt *bolt.Bucket) error {
+			if isReserved(string(name)) {
+				return nil
+			}
+			//the buckets with their own secret or cryptor are not rekeyed
+			if _, ok := dbm.bucketConfigs[string(name)]; ok {
+				return nil
+			}
+			names = append(names, append([]byte(nil), name...))
+
+			//a blob is counted once, not by the chunks in its sub-bucket
+			cursor := bkt.Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if v != nil || bkt.Bucket(k).Get(blobHeaderKey) != nil {
+					p.Total++
+				}
+			}
+			return nil
+		})
+	}
+
+	if err = dbm.db.view(list); err != nil {
+		return
+	}
+
+	for _, name := range names {
+		p.Bucket = string(name)
+
+		//the first key of the next batch, nil when the bucket is done
+		next := []byte{}
+		for next != nil {
+			var rekeyed, skipped int
+
+			rekeyBatch := func(tx *boltsecTx) error {
+				type record struct {
+					key   []byte
+					value []byte
+				}
+
+				rekeyed, skipped = 0, 0
+				bkt := tx.Bucket(name)
+				if bkt == nil {
+					next = nil
+					return nil
+				}
+
+				cursor := bkt.Cursor()
+				k, v := cursor.Seek(next)
+
+				//collect the batch first as the cursor must not be used after the bucket is changed
+				batch := make([]record, 0)
+				for n := 0; k != nil && n < RekeyBatchSize; k, v = cursor.Next() {
+					n++
+					if v == nil {
+						//the blobs are rekeyed in place, the other nested buckets are left alone
+						sub := bkt.Bucket(k)
+						if sub.Get(blobHeaderKey) == nil {
+							continue
+						}
+
+						done, err := rekeyBlob(keys, primary, string(name), k, sub)
+						if err != nil {
+							Logger.Printf("Rekey bucket %s blob %s return %s", name, k, err)
+							return err
+						}
+						if done {
+							rekeyed++
+						} else {
+							skipped++
+						}
+						continue
+					}
+
+					if env, err := parseEnvelope(v); err == nil && env.version == formatEnvelope && env.flags&flagBound != 0 &&
+						env.suite == primary.Suite() && env.keyID == primary.KeyID() {
+						skipped++
+						continue
+					}
+
+					//the values of the tenants are encrypted with their own data keys
+					if env, err := parseEnvelope(v); err == nil && env.version == formatEnvelope && env.flags&flagBound != 0 &&
+						keys.isTenantKey(env.suite, env.keyID) {
+						skipped++
+						continue
+					}
+
+					dec, flags, err := decryptValue(keys, string(name), k, v)
+					if err != nil {
+						Logger.Printf("Rekey bucket %s key %s return %s", name, k, err)
+						return err
+					}
+
+					enc, err := sealValue(primary, dec, bindTo(string(name), k), flags)
+					if err != nil {
+						return err
+					}
+					batch = append(batch, record{key: append([]byte(nil), k...), value: enc})
+				}
+
+				if k != nil {
+					next = append([]byte(nil), k...)
+				} else {
+					next = nil
+				}
+
+				for _, r := range batch {
+					if err := bkt.Put(r.key, r.value); err != nil {
+						return err
+					}
+					if err := dbm.updateDigest(tx, keys, string(name), r.key, r.value); err != nil {
+						return err
+					}
+				}
+				rekeyed += len(batch)
+				return nil
+			}
+
+			if err = dbm.db.update(rekeyBatch); err != nil {
+				return
+			}
+
+			p.Rekeyed += rekeyed
+			p.Skipped += skipped
+			if progress != nil {
+				progress(p)
+			}
+		}
+	}
+
+	//the digests and the indexes of a plain text db are computed without the random keys
+	if current == nil {
+		for _, name := range names {
+			if dbm.hasIntegrity(string(name)) {
+				if err = dbm.RebuildDigest(string(name)); err != nil {
+					return
+				}
+			}
+			if _, ok := dbm.indexes[string(name)]; ok {
+				if err = dbm.RebuildIndex(string(name)); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	if err = dbm.resealMetaKeys(keys); err != nil {
+		return
+	}
+	if err = dbm.writeCanary(primary, canaryRecord, true); err != nil {
+		return
+	}
+	//all the values are in the envelope now
+	if err = dbm.markNoLegacy(); err != nil {
+		return
+	}
+
+	newKeys.noLegacy = true
+	newKeys.inherit(keys)
+	dbm.setKeys(newSecret, newKeys)
+	return nil
+}
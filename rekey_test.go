@@ -0,0 +1,169 @@
+package boltsec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDBMRekey(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "old", false, []string{bucketName, "other"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	for i := 0; i < 25; i++ {
+		data := Article{ID: fmt.Sprintf("ID-%04d", i), Title: "input with more than 16 characters"}
+		if err = dbm.Save(bucketName, data.ID, data); err != nil {
+			t.Fatalf("save data return err: %s", err)
+		}
+	}
+
+	if err = dbm.Rekey("wrong", "new", nil); err != ErrWrongSecret {
+		t.Errorf("Rekey with wrong old secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+
+	batchSize := RekeyBatchSize
+	RekeyBatchSize = 10
+	defer func() { RekeyBatchSize = batchSize }()
+
+	var last RekeyProgress
+	batches := 0
+	progress := func(p RekeyProgress) {
+		batches++
+		last = p
+	}
+
+	if err = dbm.Rekey("old", "new", progress); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if last.Total != 25 || last.Rekeyed != 25 || last.Skipped != 0 || batches != 4 {
+		t.Errorf("Rekey progress: %+v after %d batches", last, batches)
+	}
+
+	dbm, err = NewDBManager("test.dat", dir, "new", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	results, err := dbm.GetByPrefix(bucketName, "ID-")
+	if err != nil {
+		t.Fatalf("GetByPrefix with new secret return err: %s", err)
+	}
+	if len(results) != 25 {
+		t.Errorf("GetByPrefix return %d records, expect: 25", len(results))
+	}
+	for _, iter := range results {
+		resNew := new(Article)
+		if err = json.Unmarshal(iter, resNew); err != nil {
+			t.Errorf("json.Unmarshal return err: %s", err)
+		}
+	}
+
+	//a second run skips all the values already encrypted with the new secret
+	if err = dbm.Rekey("old", "new", progress); err != nil {
+		t.Fatalf("Rekey again return err: %s", err)
+	}
+	if last.Rekeyed != 0 || last.Skipped != 25 {
+		t.Errorf("Rekey again progress: %+v", last)
+	}
+}
+
+func TestDBMRekeyPlainText(t *testing.T) {
+	var err error
+	bucketName := "article"
+	dir := t.TempDir()
+
+	dbm, err := NewDBManager("test.dat", dir, "", false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save(bucketName, data.ID, data); err != nil {
+		t.Fatalf("save data return err: %s", err)
+	}
+
+	if err = dbm.Rekey("", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	var bytes []byte
+	if bytes, err = dbm.GetOne(bucketName, data.ID); err != nil {
+		t.Fatalf("GetOne return err: %s", err)
+	}
+
+	resNew := new(Article)
+	if err = json.Unmarshal(bytes, resNew); err != nil {
+		t.Errorf("json.Unmarshal return err: %s", err)
+	}
+	if resNew.Title != data.Title {
+		t.Errorf("returned Title is not equal: new:%s, org: %s", resNew.Title, data.Title)
+	}
+}
+
+func TestDBMRekeyPlainTextKeys(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{WithIntegrity(), WithIndex("user", "email")}
+
+	dbm, err := NewDBManager("test.dat", dir, "", false, []string{"user"}, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	for _, u := range []indexedUser{{"u1", "a@example.com", 30}, {"u2", "b@example.com", 40}} {
+		if err = dbm.Save("user", u.ID, u); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+
+	if err = dbm.Rekey("", "new", nil); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+
+	//the random keys created by the Rekey are the ones loaded by the next NewDBManager
+	dbm, err = NewDBManager("test.dat", dir, "new", false, []string{"user"}, opts...)
+	if err != nil {
+		t.Fatalf("NewDBManager after Rekey return err: %s", err)
+	}
+
+	report, err := dbm.Verify()
+	if err != nil || len(report.Issues) != 0 || report.Records != 2 {
+		t.Errorf("Verify after Rekey return %+v, err: %v", report, err)
+	}
+	results, err := dbm.FindByIndex("user", "email", "b@example.com")
+	if err != nil || len(results) != 1 || !strings.Contains(string(results[0]), "u2") {
+		t.Errorf("FindByIndex after Rekey return %s, err: %v", results, err)
+	}
+}
+
+func TestDBMRekeyTotal(t *testing.T) {
+	defer func(size int) { BlobChunkSize = size }(BlobChunkSize)
+	BlobChunkSize = 16
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"article"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save("article", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+	if _, err = dbm.PutReader("article", "file", strings.NewReader(strings.Repeat(data.Title, 10))); err != nil {
+		t.Fatalf("PutReader return err: %s", err)
+	}
+
+	//the blob is counted once, not by its chunks
+	var last RekeyProgress
+	if err = dbm.Rekey("secret", "new", func(p RekeyProgress) { last = p }); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if last.Total != 2 || last.Rekeyed != 2 || last.Skipped != 0 {
+		t.Errorf("Rekey progress %+v, expect 2 in total and 2 rekeyed", last)
+	}
+}
@@ -0,0 +1,144 @@
+package boltsec
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// The maximum number of the shares, the x coordinate of a share is a non zero byte
+const maxShares = 255
+
+// SplitSecret splits the secret into n shares with Shamir's secret sharing over GF(256), any k of them
+// recover the secret while fewer than k shares reveal nothing about it. Each share is one byte longer
+// than the secret, the first byte identifies the share. The shares can be given to different operators
+// and combined with WithSecretShares or SetSecretShares to open the db.
+func SplitSecret(secret string, n, k int) ([][]byte, error) {
+	key := []byte(secret)
+	defer zero(key)
+
+	return SplitSecretBytes(key, n, k)
+}
+
+// SplitSecretBytes splits the secret given as bytes into n shares, the same as SplitSecret
+func SplitSecretBytes(secret []byte, n, k int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, ErrSecretInvalid
+	}
+	if k < 2 || k > n || n > maxShares {
+		return nil, errors.New("invalid number of shares or threshold")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	//the coefficients of the polynomial of each byte, the constant term is the byte of the secret
+	coeffs := make([]byte, k)
+	for pos := 0; pos < len(secret); pos++ {
+		coeffs[0] = secret[pos]
+		if _, err := io.ReadFull(rand.Reader, coeffs[1:]); err != nil {
+			return nil, err
+		}
+
+		for _, share := range shares {
+			share[pos+1] = gfEval(coeffs, share[0])
+		}
+	}
+
+	for i := range coeffs {
+		coeffs[i] = 0
+	}
+	return shares, nil
+}
+
+// CombineShares recovers the secret from the shares made by SplitSecret. The number of shares is not
+// stored in them, thus fewer shares than the threshold return a wrong secret instead of an error; the
+// wrong secret is reported as ErrWrongSecret when it is used to open the db.
+func CombineShares(shares ...[]byte) (string, error) {
+	secret, err := combineShares(shares...)
+	if err != nil {
+		return "", err
+	}
+	defer zero(secret)
+	return string(secret), nil
+}
+
+// The combineShares function recovers the secret as bytes, so that it can be zeroed after use
+func combineShares(shares ...[]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrSharesInvalid
+	}
+
+	size := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != size || size < 2 || share[0] == 0 || seen[share[0]] {
+			return nil, ErrSharesInvalid
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		//the Lagrange basis polynomial of the share at x = 0
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other[0], gfInv(other[0]^share[0])))
+			}
+		}
+
+		for pos := range secret {
+			secret[pos] ^= gfMul(basis, share[pos+1])
+		}
+	}
+	return secret, nil
+}
+
+// WithSecretShares sets the secret of the db combined from the shares made by SplitSecret, the secret
+// argument of NewDBManager must be "" then
+func WithSecretShares(shares ...[]byte) Option {
+	return func(dbm *DBManager) {
+		dbm.shares = shares
+	}
+}
+
+// SetSecretShares sets the secret combined from the shares made by SplitSecret, the same as SetSecret
+func (dbm *DBManager) SetSecretShares(shares ...[]byte) error {
+	secret, err := combineShares(shares...)
+	if err != nil {
+		return err
+	}
+	return dbm.setSecret(secret)
+}
+
+// The gfEval function evaluates the polynomial at x in GF(256)
+func gfEval(coeffs []byte, x byte) (y byte) {
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return
+}
+
+// The gfMul function multiplies in GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1, without
+// branches on the values so that the time doesn't depend on the secret
+func gfMul(a, b byte) (p byte) {
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return
+}
+
+// The gfInv function returns the multiplicative inverse in GF(256), which is a^254
+func gfInv(a byte) byte {
+	r := a
+	for i := 0; i < 6; i++ {
+		r = gfMul(gfMul(r, r), a)
+	}
+	return gfMul(r, r)
+}
@@ -0,0 +1,99 @@
+package boltsec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitSecret(t *testing.T) {
+	secret := "a secret of the db"
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret return err: %s", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("SplitSecret return %d shares, expect 5", len(shares))
+	}
+
+	for _, quorum := range [][][]byte{
+		{shares[0], shares[1], shares[2]},
+		{shares[4], shares[2], shares[0]},
+		{shares[1], shares[3], shares[4]},
+		shares,
+	} {
+		res, err := CombineShares(quorum...)
+		if err != nil {
+			t.Fatalf("CombineShares return err: %s", err)
+		}
+		if res != secret {
+			t.Errorf("CombineShares return %q, expect: %q", res, secret)
+		}
+	}
+
+	if res, _ := CombineShares(shares[0], shares[1]); res == secret {
+		t.Errorf("CombineShares below the threshold return the secret")
+	}
+	if _, err = CombineShares(shares[0], shares[0]); err != ErrSharesInvalid {
+		t.Errorf("CombineShares with duplicated shares return err: %v, expect: %v", err, ErrSharesInvalid)
+	}
+	if _, err = CombineShares(shares[0], shares[1][1:]); err != ErrSharesInvalid {
+		t.Errorf("CombineShares with short share return err: %v, expect: %v", err, ErrSharesInvalid)
+	}
+
+	for _, c := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err = SplitSecret(secret, c[0], c[1]); err == nil {
+			t.Errorf("SplitSecret(%d, %d) return no error", c[0], c[1])
+		}
+	}
+}
+
+func TestGFInv(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("gfMul(%d, gfInv(%d)) = %d, expect 1", a, a, p)
+		}
+	}
+}
+
+func TestDBMSecretShares(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article"}
+
+	shares, err := SplitSecret("secret", 3, 2)
+	if err != nil {
+		t.Fatalf("SplitSecret return err: %s", err)
+	}
+
+	dbm, err := NewDBManager("test.dat", dir, "", false, buckets, WithSecretShares(shares[0], shares[2]))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save("article", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//the db is readable with the combined secret
+	dbm, err = NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if res, err := dbm.GetOne("article", data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+		t.Errorf("GetOne return %s, err: %v", res, err)
+	}
+
+	if err = dbm.SetSecretShares(shares[1], shares[2]); err != nil {
+		t.Errorf("SetSecretShares return err: %s", err)
+	}
+
+	other, _ := SplitSecret("wrongs", 3, 2)
+	if err = dbm.SetSecretShares(shares[1], other[2]); err != ErrWrongSecret {
+		t.Errorf("SetSecretShares with share of another secret return err: %v, expect: %v", err, ErrWrongSecret)
+	}
+
+	if _, err = NewDBManager("test.dat", dir, "secret", false, buckets, WithSecretShares(shares...)); err != ErrSecretInvalid {
+		t.Errorf("NewDBManager with secret and shares return err: %v, expect: %v", err, ErrSecretInvalid)
+	}
+}
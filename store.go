@@ -0,0 +1,64 @@
+package boltsec
+
+// The Store struct is a typed view of a bucket, the values are encoded with the codec of the bucket and
+// encrypted like the values saved by Save, thus the records of a Store can also be read by GetOne,
+// GetByPrefix, etc. Each value is decoded with the codec which encoded it, see Codec.
+type Store[T any] struct {
+	dbm    *DBManager
+	bucket string
+}
+
+// NewStore returns the Store of the bucket, the bucket must be given to NewDBManager
+func NewStore[T any](dbm *DBManager, bucket string) *Store[T] {
+	return &Store[T]{dbm: dbm, bucket: bucket}
+}
+
+// The Get function returns the value of the record of the key, ErrNotFound is returned if there is no
+// record of the key.
+func (s *Store[T]) Get(key string) (value T, err error) {
+	r, err := s.dbm.getRecord(s.bucket, key)
+	if err != nil {
+		return
+	}
+	return s.decode(r)
+}
+
+// The Put function stores the value as the record of the key
+func (s *Store[T]) Put(key string, value T) error {
+	return s.dbm.Save(s.bucket, key, value)
+}
+
+// The List function returns the values of the records with the prefix in the order of the keys, all the
+// records if the prefix is "". An error is returned if a value cannot be decrypted or decoded.
+func (s *Store[T]) List(prefix string) ([]T, error) {
+	records, err := s.dbm.findRecords(s.bucket, prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(records))
+	for _, r := range records {
+		value, err := s.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+	return results, nil
+}
+
+// The Delete function deletes the record of the key
+func (s *Store[T]) Delete(key string) error {
+	return s.dbm.Delete(s.bucket, key)
+}
+
+// The decode function returns the value of the record decoded with its codec
+func (s *Store[T]) decode(r record) (value T, err error) {
+	codec, err := s.dbm.lookupCodec(r.codec)
+	if err != nil {
+		return
+	}
+
+	err = codec.Unmarshal(r.value, &value)
+	return
+}
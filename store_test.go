@@ -0,0 +1,51 @@
+package boltsec
+
+import (
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	var err error
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"article"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	store := NewStore[Article](dbm, "article")
+	articles := []Article{
+		{ID: "a-002", Title: "input with more than 16 characters"},
+		{ID: "a-001", Title: "another input"},
+		{ID: "b-001", Title: "not in the list"},
+	}
+	for _, a := range articles {
+		if err = store.Put(a.ID, a); err != nil {
+			t.Fatalf("Put %s return err: %s", a.ID, err)
+		}
+	}
+
+	if res, err := store.Get("a-002"); err != nil || res != articles[0] {
+		t.Errorf("Get return %+v, err: %v, expect: %+v", res, err, articles[0])
+	}
+
+	list, err := store.List("a-")
+	if err != nil {
+		t.Fatalf("List return err: %s", err)
+	}
+	if len(list) != 2 || list[0] != articles[1] || list[1] != articles[0] {
+		t.Errorf("List return %+v", list)
+	}
+
+	if err = store.Delete("a-002"); err != nil {
+		t.Fatalf("Delete return err: %s", err)
+	}
+	if _, err = store.Get("a-002"); err != ErrNotFound {
+		t.Errorf("Get deleted record return err: %v, expect: %v", err, ErrNotFound)
+	}
+
+	//the values of the store are not of the type
+	other := NewStore[int](dbm, "article")
+	if _, err = other.List(""); err == nil {
+		t.Errorf("List of values of another type return no err")
+	}
+}
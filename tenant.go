@@ -0,0 +1,266 @@
+package boltsec
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+)
+
+// The prefix of the metadata records which keep the data keys of the tenants, they are random keys encrypted
+// with the secret of the db, thus they are re-encrypted by the Rekey as the other random keys
+const tenantKeyPrefix = metaKeyPrefix + "tenant/"
+
+// The prefix of the metadata records which keep the key identifiers of the shredded tenants, so that the
+// read paths can tell a shredded value from a value of an unknown key
+const shreddedPrefix = "shredded/"
+
+// The size of a key in the shredded records, i.e. the suite and the KeyID
+const shreddedKeySize = 1 + keyIDSize
+
+// SaveTenant stores the record into the db file the same as Save, but the value is encrypted with the data
+// key of the tenant instead of the secret of the db. The data key is a random key created on the first
+// save of the tenant, it is stored in the db encrypted with the secret of the db. The values of a tenant
+// are read by GetOne, GetByPrefix, etc. as any other value, until the tenant is shredded by ShredTenant.
+// It requires the secret (or a cryptor or a key provider) of the db, the buckets with their own secret
+// or cryptor set by WithBucketSecret or WithBucketCryptor are not supported.
+func (dbm *DBManager) SaveTenant(tenant, bucket, key string, data interface{}) error {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return err
+	}
+	defer dbm.closeDB()
+
+	if tenant == "" {
+		return ErrTenantInvalid
+	}
+	if _, ok := dbm.bucketConfigs[bucket]; ok {
+		return errors.New("cannot save tenant data in a bucket with its own secret")
+	}
+
+	//the data key is created before the transaction of the save, as it is stored in its own transaction
+	_, c, err := dbm.tenantCryptor(tenant)
+	if err != nil {
+		return err
+	}
+
+	save := func(tx *Tx) error {
+		return tx.put(c, bucket, key, data)
+	}
+
+	return dbm.Update(save)
+}
+
+// ShredTenant destroys the data key of the tenant, thus all the values saved by SaveTenant for the tenant
+// become permanently unreadable, in every bucket, and the read paths return ErrShredded for them. The
+// values stay in the db until they are deleted. A tenant which doesn't exist or is already shredded is
+// ignored; a later SaveTenant for the same tenant creates a new data key.
+//
+// The data key encrypted with the secret of the db is removed from the db file by a Compact, as bolt keeps
+// the deleted values in its free pages, thus ShredTenant waits for the operations already running and must
+// not be called in the function of Update or View. The backups of the db made before the ShredTenant still
+// contain the data key encrypted with the secret of the db. To shred the tenant in the backups too, Rekey
+// the db to a new secret (or rewrap the data key with RewrapKey) and destroy the old secret.
+func (dbm *DBManager) ShredTenant(tenant string) (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	if err = dbm.shredTenant(tenant); err != nil {
+		return
+	}
+	return dbm.compactDB()
+}
+
+// The shredTenant function removes the data key of the tenant from the db and from the keyrings, and adds
+// its identifiers to the tombstone of the tenant
+func (dbm *DBManager) shredTenant(tenant string) (err error) {
+	dbm.rekeyMu.Lock()
+	defer dbm.rekeyMu.Unlock()
+
+	if tenant == "" {
+		return ErrTenantInvalid
+	}
+
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	keys, err := dbm.currentKeys()
+	if err != nil {
+		return
+	}
+	if keys == nil {
+		return ErrSecretInvalid
+	}
+
+	var shredded []keyringKey
+	name := []byte(tenantKeyPrefix + tenant)
+	shred := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		v := bkt.Get(name)
+		if v == nil {
+			return nil
+		}
+
+		key, _, err := openValue(keys, append([]byte(nil), v...), bindTo(metaBucket, name))
+		if err != nil {
+			return err
+		}
+
+		tk, err := dbm.newSuiteKeyring(nil, key)
+		if err != nil {
+			return err
+		}
+
+		tombstone := append([]byte(nil), bkt.Get([]byte(shreddedPrefix+tenant))...)
+		for k := range tk.keys {
+			shredded = append(shredded, k)
+			tombstone = append(tombstone, byte(k.suite))
+			tombstone = append(tombstone, k.id[:]...)
+		}
+
+		if err := bkt.Put([]byte(shreddedPrefix+tenant), tombstone); err != nil {
+			return err
+		}
+		return bkt.Delete(name)
+	}
+
+	if err = dbm.db.update(shred); err != nil {
+		return
+	}
+
+	dbm.keysMu.Lock()
+	defer dbm.keysMu.Unlock()
+
+	if dbm.keys != nil && !dbm.locked {
+		kr := dbm.keys.clone()
+		delete(kr.tenants, tenant)
+		for _, k := range shredded {
+			kr.shred(k)
+		}
+		dbm.keys = kr
+	}
+	return nil
+}
+
+// The tenantCryptor function returns the keyring of the db and the primary cryptor of the data key of the
+// tenant, the data key is created if the tenant doesn't have one
+func (dbm *DBManager) tenantCryptor(tenant string) (*keyring, Cryptor, error) {
+	keys, err := dbm.currentKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	if keys == nil {
+		return nil, nil, ErrSecretInvalid
+	}
+	if c, ok := keys.tenants[tenant]; ok {
+		return keys, c, nil
+	}
+
+	dbm.rekeyMu.Lock()
+	defer dbm.rekeyMu.Unlock()
+
+	if keys, err = dbm.currentKeys(); err != nil {
+		return nil, nil, err
+	}
+	if keys == nil {
+		return nil, nil, ErrSecretInvalid
+	}
+	if c, ok := keys.tenants[tenant]; ok {
+		return keys, c, nil
+	}
+
+	var key []byte
+	name := []byte(tenantKeyPrefix + tenant)
+	create := func(tx *boltsecTx) error {
+		bkt := tx.Bucket([]byte(metaBucket))
+		binding := bindTo(metaBucket, name)
+		if v := bkt.Get(name); v != nil {
+			dec, _, err := openValue(keys, append([]byte(nil), v...), binding)
+			key = dec
+			return err
+		}
+
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return err
+		}
+
+		enc, err := sealValue(keys.primary, key, binding, 0)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(name, enc)
+	}
+
+	if err := dbm.db.update(create); err != nil {
+		return nil, nil, err
+	}
+
+	dbm.keysMu.Lock()
+	defer dbm.keysMu.Unlock()
+
+	if dbm.keys == nil || dbm.locked {
+		return nil, nil, ErrLocked
+	}
+	kr := dbm.keys.clone()
+	if err := dbm.addTenant(kr, tenant, key); err != nil {
+		return nil, nil, err
+	}
+	dbm.keys = kr
+	return kr, kr.tenants[tenant], nil
+}
+
+// The addTenant function adds the cryptors of the data key of the tenant to the keyring
+func (dbm *DBManager) addTenant(keys *keyring, tenant string, key []byte) error {
+	tk, err := dbm.newSuiteKeyring(nil, key)
+	if err != nil {
+		return err
+	}
+
+	if keys.tenants == nil {
+		keys.tenants = make(map[string]Cryptor)
+	}
+	keys.tenants[tenant] = tk.primary
+	for k, c := range tk.keys {
+		keys.addTenantKey(k, c, tenant)
+	}
+	return nil
+}
+
+// The loadTenants function adds the data keys of the tenants and the keys of the shredded tenants stored
+// in the db to the keyring
+func (dbm *DBManager) loadTenants(keys *keyring) (err error) {
+	if err = dbm.openDB(); err != nil {
+		return
+	}
+	defer dbm.closeDB()
+
+	load := func(tx *boltsecTx) error {
+		cursor := tx.Bucket([]byte(metaBucket)).Cursor()
+		for k, v := cursor.Seek([]byte(tenantKeyPrefix)); k != nil && strings.HasPrefix(string(k), tenantKeyPrefix); k, v = cursor.Next() {
+			key, _, err := openValue(keys, append([]byte(nil), v...), bindTo(metaBucket, k))
+			if err != nil {
+				return err
+			}
+			if err := dbm.addTenant(keys, strings.TrimPrefix(string(k), tenantKeyPrefix), key); err != nil {
+				return err
+			}
+		}
+
+		for k, v := cursor.Seek([]byte(shreddedPrefix)); k != nil && strings.HasPrefix(string(k), shreddedPrefix); k, v = cursor.Next() {
+			for ; len(v) >= shreddedKeySize; v = v[shreddedKeySize:] {
+				var id KeyID
+				copy(id[:], v[1:shreddedKeySize])
+				keys.shred(keyringKey{Suite(v[0]), id})
+			}
+		}
+		return nil
+	}
+
+	return dbm.db.view(load)
+}
@@ -0,0 +1,131 @@
+package boltsec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBMShredTenant(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	buckets := []string{"article", "comment"}
+
+	dbm, err := NewDBManager("test.dat", dir, "secret", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	for _, bucket := range buckets {
+		if err = dbm.SaveTenant("acme", bucket, data.ID, data); err != nil {
+			t.Fatalf("SaveTenant to %s return err: %s", bucket, err)
+		}
+		if err = dbm.SaveTenant("other", bucket, "ID-0002", data); err != nil {
+			t.Fatalf("SaveTenant to %s return err: %s", bucket, err)
+		}
+	}
+	if err = dbm.Save("article", "ID-0003", data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	res, err := dbm.GetOne("comment", data.ID)
+	if err != nil {
+		t.Fatalf("GetOne tenant value return err: %s", err)
+	}
+	resNew := new(Article)
+	if err = json.Unmarshal(res, resNew); err != nil || resNew.Title != data.Title {
+		t.Errorf("GetOne tenant value return %s, expect: %s", res, data.Title)
+	}
+
+	//the tenant keys survive the Rekey and the values of the tenants are not rewritten
+	var progress RekeyProgress
+	if err = dbm.Rekey("secret", "new", func(p RekeyProgress) { progress = p }); err != nil {
+		t.Fatalf("Rekey return err: %s", err)
+	}
+	if progress.Skipped != 4 || progress.Rekeyed != 1 {
+		t.Errorf("Rekey progress %+v, expect 4 skipped and 1 rekeyed", progress)
+	}
+
+	//the wrapped data key of the tenant is removed from the db file, not only from the bucket
+	var wrapped []byte
+	read := func(tx *boltsecTx) error {
+		wrapped = append([]byte(nil), tx.Bucket([]byte(metaBucket)).Get([]byte(tenantKeyPrefix+"acme"))...)
+		return nil
+	}
+	if err = dbm.openDB(); err != nil {
+		t.Fatalf("openDB return err: %s", err)
+	}
+	err = dbm.db.view(read)
+	dbm.closeDB()
+	if err != nil || len(wrapped) == 0 {
+		t.Fatalf("read wrapped tenant key return %d bytes, err: %v", len(wrapped), err)
+	}
+
+	if err = dbm.ShredTenant("acme"); err != nil {
+		t.Fatalf("ShredTenant return err: %s", err)
+	}
+	file, err := os.ReadFile(filepath.Join(dir, "test.dat"))
+	if err != nil {
+		t.Fatalf("ReadFile return err: %s", err)
+	}
+	if bytes.Contains(file, wrapped) {
+		t.Errorf("db file still contains the wrapped key of the shredded tenant")
+	}
+
+	for _, bucket := range buckets {
+		if _, err = dbm.GetOne(bucket, data.ID); !errors.Is(err, ErrShredded) {
+			t.Errorf("GetOne shredded value of %s return err: %v, expect: %v", bucket, err, ErrShredded)
+		}
+	}
+
+	//the shredded tenant stays shredded when the db is opened again, the others are still readable
+	dbm, err = NewDBManager("test.dat", dir, "new", false, buckets)
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+	if _, err = dbm.GetOne("article", data.ID); !errors.Is(err, ErrShredded) {
+		t.Errorf("GetOne shredded value after reopen return err: %v, expect: %v", err, ErrShredded)
+	}
+	for _, key := range []string{"ID-0002", "ID-0003"} {
+		if res, err = dbm.GetOne("article", key); err != nil || res == nil {
+			t.Errorf("GetOne %s after ShredTenant return %s, err: %v", key, res, err)
+		}
+	}
+
+	//a new data key is created for the tenant
+	if err = dbm.SaveTenant("acme", "article", "ID-0004", data); err != nil {
+		t.Fatalf("SaveTenant after ShredTenant return err: %s", err)
+	}
+	if res, err = dbm.GetOne("article", "ID-0004"); err != nil || res == nil {
+		t.Errorf("GetOne new tenant value return %s, err: %v", res, err)
+	}
+	if _, err = dbm.GetOne("article", data.ID); !errors.Is(err, ErrShredded) {
+		t.Errorf("GetOne shredded value return err: %v, expect: %v", err, ErrShredded)
+	}
+
+	//the records of the reserved buckets cannot be overwritten
+	if err = dbm.SaveTenant("acme", metaBucket, canaryRecord, data); err != ErrBucketReserved {
+		t.Errorf("SaveTenant to reserved bucket return err: %v, expect: %v", err, ErrBucketReserved)
+	}
+	if _, err = NewDBManager("test.dat", dir, "new", false, buckets); err != nil {
+		t.Errorf("NewDBManager after SaveTenant to reserved bucket return err: %s", err)
+	}
+}
+
+func TestDBMSaveTenantPlain(t *testing.T) {
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "", false, []string{"article"})
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	if err = dbm.SaveTenant("acme", "article", "ID-0001", Article{}); err != ErrSecretInvalid {
+		t.Errorf("SaveTenant without secret return err: %v, expect: %v", err, ErrSecretInvalid)
+	}
+	if err = dbm.SaveTenant("", "article", "ID-0001", Article{}); err != ErrTenantInvalid {
+		t.Errorf("SaveTenant without tenant return err: %v, expect: %v", err, ErrTenantInvalid)
+	}
+}
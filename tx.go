@@ -0,0 +1,241 @@
+package boltsec
+
+import (
+	"bytes"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+)
+
+// The Tx struct is a transaction of Update or View, its functions encrypt and decrypt the values of the
+// records the same as Save, Get and Delete of the DBManager, and they keep the blind indexes and the
+// digests of the buckets up to date. A Tx is only valid in the function given to Update or View.
+type Tx struct {
+	dbm  *DBManager
+	tx   *boltsecTx
+	keys map[string]*keyring
+}
+
+// Update runs the function in a read-write transaction, the changes of the function are committed if it
+// returns nil, and rolled back if it returns an error, e.g. to move a record or to read, modify and write
+// a record atomically. There can be only one Update at a time.
+func (dbm *DBManager) Update(fn func(tx *Tx) error) error {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return err
+	}
+	defer dbm.closeDB()
+
+	update := func(tx *boltsecTx) error {
+		return fn(&Tx{dbm: dbm, tx: tx})
+	}
+
+	return dbm.db.update(update)
+}
+
+// View runs the function in a read-only transaction, which sees the db as it is when the transaction
+// starts. The Put and Delete of the Tx of View return bolt.ErrTxNotWritable.
+func (dbm *DBManager) View(fn func(tx *Tx) error) error {
+	var err error
+
+	if err = dbm.openDB(); err != nil {
+		return err
+	}
+	defer dbm.closeDB()
+
+	view := func(tx *boltsecTx) error {
+		return fn(&Tx{dbm: dbm, tx: tx})
+	}
+
+	return dbm.db.view(view)
+}
+
+// The Get function returns the record of the key, it is decrypted if the secret is set. ErrNotFound is
+// returned if there is no record of the key.
+func (tx *Tx) Get(bucket, key string) ([]byte, error) {
+	r, err := tx.record(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return r.value, nil
+}
+
+// The Put function stores the record of the key, the data is encoded with the codec of the bucket and
+// encrypted if the secret is set, see Save.
+func (tx *Tx) Put(bucket, key string, data interface{}) error {
+	return tx.put(nil, bucket, key, data)
+}
+
+// The put function stores the record encrypted with the cryptor, e.g. the one of a tenant, or with the
+// primary cryptor of the keyring of the bucket if it is nil
+func (tx *Tx) put(c Cryptor, bucket, key string, data interface{}) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if key == "" {
+		return ErrKeyInvalid
+	}
+
+	bkt, keys, err := tx.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	value, err := tx.dbm.codecFor(bucket).Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	k, v, err := tx.dbm.sealRecord(keys, c, bucket, []byte(key), value)
+	if err != nil {
+		return err
+	}
+
+	return tx.dbm.putRecord(tx.tx, keys, bkt, bucket, k, v, value)
+}
+
+// The Delete function deletes the record of the key, or the blob stored by PutReader.
+func (tx *Tx) Delete(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+
+	bkt, keys, err := tx.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	k := tx.dbm.storageKey(keys, bucket, []byte(key))
+	if bkt.Bucket(k) != nil {
+		return bkt.DeleteBucket(k)
+	}
+	return tx.dbm.deleteRecord(tx.tx, keys, bkt, bucket, k)
+}
+
+// The Cursor function returns a Cursor over the records of the bucket in the order of their keys
+func (tx *Tx) Cursor(bucket string) (*Cursor, error) {
+	bkt, keys, err := tx.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cursor{bucket: bucket, keys: keys}
+	if !tx.dbm.encryptsKeys(keys, bucket) {
+		c.cursor = bkt.Cursor()
+		return c, nil
+	}
+
+	//the stored keys are hashes, thus the records are decrypted and sorted by their keys
+	if c.records, err = tx.dbm.seekRecords(tx.tx, keys, bucket, nil, 0, false); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// The record function returns the decrypted record of the key
+func (tx *Tx) record(bucket, key string) (r record, err error) {
+	if key == "" {
+		return r, ErrKeyInvalid
+	}
+
+	bkt, keys, err := tx.bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	k := tx.dbm.storageKey(keys, bucket, []byte(key))
+	v := bkt.Get(k)
+	if v == nil {
+		return r, ErrNotFound
+	}
+	return tx.dbm.decodeRecord(keys, bucket, k, v)
+}
+
+// The bucket function returns the bolt bucket and the keyring of the bucket, the keyring is kept for the
+// transaction so that all its records are encrypted with the same keys
+func (tx *Tx) bucket(bucket string) (*bolt.Bucket, *keyring, error) {
+	if isReserved(bucket) {
+		return nil, nil, ErrBucketReserved
+	}
+
+	bkt := tx.tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil, nil, ErrBucketNotFound
+	}
+
+	keys, ok := tx.keys[bucket]
+	if !ok {
+		var err error
+		if keys, err = tx.dbm.keysFor(bucket); err != nil {
+			return nil, nil, err
+		}
+		if tx.keys == nil {
+			tx.keys = make(map[string]*keyring)
+		}
+		tx.keys[bucket] = keys
+	}
+	return bkt, keys, nil
+}
+
+// The Cursor struct iterates over the decrypted records of a bucket in the order of their keys, the blobs
+// stored by PutReader are skipped. The records must not be deleted while they are iterated.
+type Cursor struct {
+	bucket string
+	keys   *keyring
+	cursor *bolt.Cursor
+	//the sorted records of a bucket whose keys are encrypted
+	records []record
+	index   int
+}
+
+// The First function moves the cursor to the first record and returns it, the key is nil if the bucket is
+// empty
+func (c *Cursor) First() (key, value []byte, err error) {
+	if c.cursor == nil {
+		c.index = 0
+		return c.current()
+	}
+	return c.open(c.cursor.First())
+}
+
+// The Next function moves the cursor to the next record and returns it, the key is nil at the end of the
+// bucket
+func (c *Cursor) Next() (key, value []byte, err error) {
+	if c.cursor == nil {
+		if c.index < len(c.records) {
+			c.index++
+		}
+		return c.current()
+	}
+	return c.open(c.cursor.Next())
+}
+
+// The Seek function moves the cursor to the first record whose key is not less than the seek key and returns
+// it, the key is nil if there is none. Use it with bytes.HasPrefix to iterate over the keys with a prefix.
+func (c *Cursor) Seek(seek []byte) (key, value []byte, err error) {
+	if c.cursor == nil {
+		c.index = sort.Search(len(c.records), func(i int) bool { return bytes.Compare(c.records[i].key, seek) >= 0 })
+		return c.current()
+	}
+	return c.open(c.cursor.Seek(seek))
+}
+
+// The current function returns the record of the index of a bucket whose keys are encrypted
+func (c *Cursor) current() (key, value []byte, err error) {
+	if c.index >= len(c.records) {
+		return nil, nil, nil
+	}
+	return c.records[c.index].key, c.records[c.index].value, nil
+}
+
+// The open function returns the decrypted record of the stored key, the blobs are skipped
+func (c *Cursor) open(k, v []byte) (key, value []byte, err error) {
+	for k != nil && v == nil {
+		k, v = c.cursor.Next()
+	}
+	if k == nil {
+		return nil, nil, nil
+	}
+	return openRecord(c.keys, c.bucket, k, v)
+}
@@ -0,0 +1,135 @@
+package boltsec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"testing"
+)
+
+func TestDBMUpdate(t *testing.T) {
+	var err error
+
+	dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"draft", "article"}, WithIndex("article", "title"))
+	if err != nil {
+		t.Fatalf("NewDBManager return err: %s", err)
+	}
+
+	data := Article{ID: "ID-0001", Title: "input with more than 16 characters"}
+	if err = dbm.Save("draft", data.ID, data); err != nil {
+		t.Fatalf("Save return err: %s", err)
+	}
+
+	//the record is moved atomically
+	move := func(tx *Tx) error {
+		value, err := tx.Get("draft", data.ID)
+		if err != nil {
+			return err
+		}
+		if err = tx.Put("article", data.ID, json.RawMessage(value)); err != nil {
+			return err
+		}
+		return tx.Delete("draft", data.ID)
+	}
+	if err = dbm.Update(move); err != nil {
+		t.Fatalf("Update return err: %s", err)
+	}
+	if _, err = dbm.Get("draft", data.ID); err != ErrNotFound {
+		t.Errorf("Get moved record return err: %v, expect: %v", err, ErrNotFound)
+	}
+	if res, err := dbm.FindByIndex("article", "title", data.Title); err != nil || len(res) != 1 {
+		t.Errorf("FindByIndex moved record return %d records, err: %v", len(res), err)
+	}
+
+	//the changes are rolled back if the function returns an error
+	failed := errors.New("failed")
+	rollback := func(tx *Tx) error {
+		if err := tx.Put("draft", "ID-0002", data); err != nil {
+			return err
+		}
+		if err := tx.Delete("article", data.ID); err != nil {
+			return err
+		}
+		return failed
+	}
+	if err = dbm.Update(rollback); err != failed {
+		t.Errorf("Update return err: %v, expect: %v", err, failed)
+	}
+	if _, err = dbm.Get("draft", "ID-0002"); err != ErrNotFound {
+		t.Errorf("Get rolled back record return err: %v, expect: %v", err, ErrNotFound)
+	}
+	if _, err = dbm.Get("article", data.ID); err != nil {
+		t.Errorf("Get record of rolled back Delete return err: %s", err)
+	}
+
+	view := func(tx *Tx) error {
+		if res, err := tx.Get("article", data.ID); err != nil || !bytes.Contains(res, []byte(data.Title)) {
+			t.Errorf("Tx.Get return %s, err: %v", res, err)
+		}
+		if err := tx.Put("article", "ID-0002", data); err != bolt.ErrTxNotWritable {
+			t.Errorf("Tx.Put in View return err: %v, expect: %v", err, bolt.ErrTxNotWritable)
+		}
+		if _, err := tx.Get(metaBucket, canaryRecord); err != ErrBucketReserved {
+			t.Errorf("Tx.Get of reserved bucket return err: %v, expect: %v", err, ErrBucketReserved)
+		}
+		return nil
+	}
+	if err = dbm.View(view); err != nil {
+		t.Fatalf("View return err: %s", err)
+	}
+}
+
+func TestDBMCursor(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithKeyEncryption()}} {
+		dbm, err := NewDBManager("test.dat", t.TempDir(), "secret", false, []string{"article"}, opts...)
+		if err != nil {
+			t.Fatalf("NewDBManager return err: %s", err)
+		}
+
+		keys := []string{"a-001", "a-002", "b-001", "b-002"}
+		for _, key := range keys {
+			if err = dbm.Save("article", key, Article{ID: key}); err != nil {
+				t.Fatalf("Save return err: %s", err)
+			}
+		}
+		if _, err = dbm.PutReader("article", "a-003", bytes.NewReader([]byte("blob"))); err != nil {
+			t.Fatalf("PutReader return err: %s", err)
+		}
+
+		view := func(tx *Tx) error {
+			c, err := tx.Cursor("article")
+			if err != nil {
+				return err
+			}
+
+			found := make([]string, 0)
+			for k, v, err := c.First(); k != nil || err != nil; k, v, err = c.Next() {
+				if err != nil {
+					return err
+				}
+				if !bytes.Contains(v, k) {
+					t.Errorf("Cursor return value %s for key %s", v, k)
+				}
+				found = append(found, string(k))
+			}
+			for i := range keys {
+				if len(found) != len(keys) || found[i] != keys[i] {
+					t.Errorf("Cursor return %v, expect: %v", found, keys)
+					break
+				}
+			}
+
+			if k, _, err := c.Seek([]byte("b-")); err != nil || string(k) != "b-001" {
+				t.Errorf("Seek return %s, err: %v", k, err)
+			}
+			if k, _, err := c.Seek([]byte("c-")); err != nil || k != nil {
+				t.Errorf("Seek after the last key return %s, err: %v", k, err)
+			}
+			return nil
+		}
+		if err = dbm.View(view); err != nil {
+			t.Fatalf("View return err: %s", err)
+		}
+	}
+}